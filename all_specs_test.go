@@ -24,3 +24,11 @@ func setupTestConfigWithNamespace(namespace string) {
 	rc := Config.Client
 	rc.FlushDB().Result()
 }
+
+// forgetManager removes a queue's manager once a test is done with it.
+func forgetManager(queue string) {
+	managersM.Lock()
+	defer managersM.Unlock()
+
+	delete(managers, queue)
+}
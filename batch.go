@@ -0,0 +1,443 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// This is a variable for testing reasons
+var batchTTL = 30 * 24 * time.Hour
+
+// ErrBatchComplete is returned when adding jobs to a batch whose callbacks
+// have already run.
+var ErrBatchComplete = errors.New("batch is already complete")
+
+// Batch groups jobs so callbacks can run once all of them are done. Jobs
+// are added with Enqueue, and callbacks only run once the batch has been
+// committed with Commit, so they can't fire while jobs are still being
+// added.
+//
+// A batch is complete once every job has either succeeded or failed at
+// least once, and successful once every job has succeeded, possibly after
+// retries. A child batch counts as one of its parent's jobs.
+type Batch struct {
+	ID          string
+	Description string
+
+	parent     string
+	onSuccess  *batchCallback
+	onComplete *batchCallback
+	saved      bool
+}
+
+type batchCallback struct {
+	Queue string      `json:"queue"`
+	Class string      `json:"class"`
+	Args  interface{} `json:"args"`
+}
+
+// BatchStatus is the progress of a batch.
+type BatchStatus struct {
+	ID          string
+	Description string
+	Parent      string
+	Total       int64
+	Pending     int64
+	Failures    int64
+	CreatedAt   time.Time
+	CompletedAt time.Time
+	SucceededAt time.Time
+}
+
+// Complete reports whether every job has succeeded or failed at least once.
+func (s *BatchStatus) Complete() bool {
+	return !s.CompletedAt.IsZero()
+}
+
+// Succeeded reports whether every job has succeeded.
+func (s *BatchStatus) Succeeded() bool {
+	return !s.SucceededAt.IsZero()
+}
+
+// NewBatch starts a new batch.
+func NewBatch(description string) *Batch {
+	return &Batch{ID: generateJid(), Description: description}
+}
+
+// OpenBatch returns an existing batch, to add jobs to it or check its
+// status. Jobs should only be added to a running batch from one of its own
+// jobs, so it can't complete in between.
+func OpenBatch(id string) (*Batch, error) {
+	fields, err := Config.Client.HGetAll(batchKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no batch with ID " + id)
+	}
+
+	return &Batch{
+		ID:          id,
+		Description: fields["description"],
+		parent:      fields["parent"],
+		saved:       true,
+	}, nil
+}
+
+// Child starts a batch nested in this one. The parent isn't complete until
+// the child is.
+func (b *Batch) Child(description string) *Batch {
+	child := NewBatch(description)
+	child.parent = b.ID
+	return child
+}
+
+// OnSuccess enqueues a job once every job in the batch has succeeded.
+// Include the batch ID in args to look up the batch from the callback.
+func (b *Batch) OnSuccess(queue, class string, args interface{}) {
+	b.onSuccess = &batchCallback{queue, class, args}
+}
+
+// OnComplete enqueues a job once every job in the batch has succeeded or
+// failed at least once.
+func (b *Batch) OnComplete(queue, class string, args interface{}) {
+	b.onComplete = &batchCallback{queue, class, args}
+}
+
+// Enqueue adds a job to the batch.
+func (b *Batch) Enqueue(queue, class string, args interface{}, opts EnqueueOptions) (string, error) {
+	if err := b.save(); err != nil {
+		return "", err
+	}
+
+	opts.Batch = b.ID
+	data := newEnqueueData(queue, class, args, opts)
+
+	// Count the job before it's enqueued, so it can't finish first
+	if err := addToBatch(b.ID, data.Jid); err != nil {
+		return "", err
+	}
+
+	if pushed, err := enqueue(data); err != nil || !pushed {
+		removeFromBatch(b.ID, data.Jid)
+		return "", err
+	}
+
+	return data.Jid, nil
+}
+
+// Commit lets the batch's callbacks run once its jobs are done, including
+// straight away if they're done already. Committing again enqueues
+// callbacks that failed to enqueue.
+func (b *Batch) Commit() error {
+	if err := b.save(); err != nil {
+		return err
+	}
+
+	rc := Config.Client
+
+	if err := rc.HSet(batchKey(b.ID), "committed", 1).Err(); err != nil {
+		return err
+	}
+
+	return finishBatchJob(b.ID, "", true)
+}
+
+// Status returns the batch's progress.
+func (b *Batch) Status() (*BatchStatus, error) {
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	fields := pipe.HGetAll(batchKey(b.ID))
+	pending := pipe.SCard(batchPendingKey(b.ID))
+	failures := pipe.SCard(batchFailedKey(b.ID))
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	if len(fields.Val()) == 0 {
+		return nil, errors.New("no batch with ID " + b.ID)
+	}
+
+	status := &BatchStatus{
+		ID:          b.ID,
+		Description: fields.Val()["description"],
+		Parent:      fields.Val()["parent"],
+		Pending:     pending.Val(),
+		Failures:    failures.Val(),
+		CreatedAt:   batchTime(fields.Val()["created_at"]),
+		CompletedAt: batchTime(fields.Val()["completed_at"]),
+		SucceededAt: batchTime(fields.Val()["succeeded_at"]),
+	}
+	status.Total, _ = strconv.ParseInt(fields.Val()["total"], 10, 64)
+
+	return status, nil
+}
+
+// save stores the batch's description and callbacks, and the first time,
+// adds it to its parent.
+func (b *Batch) save() error {
+	rc := Config.Client
+
+	fields := map[string]interface{}{
+		"description": b.Description,
+		"parent":      b.parent,
+	}
+	for name, callback := range map[string]*batchCallback{"on_success": b.onSuccess, "on_complete": b.onComplete} {
+		if callback != nil {
+			bytes, err := json.Marshal(callback)
+			if err != nil {
+				return err
+			}
+			fields[name] = string(bytes)
+		}
+	}
+
+	if !b.saved && b.parent != "" {
+		if err := addToBatch(b.parent, batchChildID(b.ID)); err != nil {
+			return err
+		}
+	}
+
+	pipe := rc.Pipeline()
+	pipe.HSetNX(batchKey(b.ID), "created_at", nowToSecondsWithNanoPrecision())
+	pipe.HMSet(batchKey(b.ID), fields)
+	pipe.Expire(batchKey(b.ID), batchTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+
+	b.saved = true
+	return nil
+}
+
+// addBatchScript adds ARGV[1] to the pending jobs of the batch KEYS[1],
+// unless it's already complete.
+var addBatchScript = redis.NewScript(`
+if redis.call('hexists', KEYS[1], 'completed_at') == 1 then
+	return 0
+end
+if redis.call('sadd', KEYS[2], ARGV[1]) == 1 then
+	redis.call('hincrby', KEYS[1], 'total', 1)
+end
+for _, key in ipairs(KEYS) do
+	redis.call('expire', key, ARGV[2])
+end
+return 1
+`)
+
+func addToBatch(id, jid string) error {
+	added, err := addBatchScript.Run(Config.Client,
+		[]string{batchKey(id), batchPendingKey(id), batchFailedKey(id)},
+		jid, int64(batchTTL/time.Second),
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrBatchComplete
+	}
+	return nil
+}
+
+// removeBatchScript takes ARGV[1] back out of the pending jobs of the batch
+// KEYS[1], for a job that wasn't enqueued after all.
+var removeBatchScript = redis.NewScript(`
+if redis.call('srem', KEYS[2], ARGV[1]) == 1 then
+	redis.call('hincrby', KEYS[1], 'total', -1)
+end
+return 1
+`)
+
+func removeFromBatch(id, jid string) {
+	err := removeBatchScript.Run(Config.Client, []string{batchKey(id), batchPendingKey(id)}, jid).Err()
+	if err != nil {
+		Logger.Println("ERR: Couldn't remove", jid, "from batch", id, ":", err)
+	}
+}
+
+// finishBatchScript records the outcome of the job ARGV[1] in the batch
+// KEYS[1]: succeeded jobs are no longer pending, failed ones are pending
+// and failed until they succeed. Once the batch is committed, it claims
+// the complete and success callbacks, returning {complete, success} with 1
+// for the ones the caller should run.
+var finishBatchScript = redis.NewScript(`
+if redis.call('exists', KEYS[1]) == 0 then
+	return {0, 0}
+end
+if ARGV[1] ~= '' then
+	if ARGV[2] == '1' then
+		redis.call('srem', KEYS[2], ARGV[1])
+		redis.call('srem', KEYS[3], ARGV[1])
+	elseif redis.call('sismember', KEYS[2], ARGV[1]) == 1 then
+		redis.call('sadd', KEYS[3], ARGV[1])
+		redis.call('expire', KEYS[3], ARGV[4])
+	end
+end
+if redis.call('hget', KEYS[1], 'committed') ~= '1' then
+	return {0, 0}
+end
+local pending = redis.call('scard', KEYS[2])
+local failed = redis.call('scard', KEYS[3])
+local complete, success = 0, 0
+if pending == failed then
+	complete = redis.call('hsetnx', KEYS[1], 'completed_at', ARGV[3])
+end
+if pending == 0 then
+	success = redis.call('hsetnx', KEYS[1], 'succeeded_at', ARGV[3])
+end
+return {complete, success}
+`)
+
+// finishBatchJob records the outcome of a job in a batch, running the
+// batch's callbacks and updating its parent once it's done.
+func finishBatchJob(id, jid string, succeeded bool) error {
+	rc := Config.Client
+
+	flag := "0"
+	if succeeded {
+		flag = "1"
+	}
+
+	claimed, err := finishBatchScript.Run(rc,
+		[]string{batchKey(id), batchPendingKey(id), batchFailedKey(id)},
+		jid, flag, nowToSecondsWithNanoPrecision(), int64(batchTTL/time.Second),
+	).Result()
+	if err != nil {
+		return err
+	}
+
+	results, _ := claimed.([]interface{})
+	if len(results) != 2 {
+		return nil
+	}
+	complete, _ := results[0].(int64)
+	success, _ := results[1].(int64)
+	if complete == 0 && success == 0 {
+		return nil
+	}
+
+	fields, err := rc.HMGet(batchKey(id), "on_complete", "on_success", "parent").Result()
+	if err != nil {
+		releaseBatchCallbacks(id, complete, success)
+		return err
+	}
+
+	if complete == 1 {
+		if err := runBatchCallback(fields[0]); err != nil {
+			releaseBatchCallbacks(id, complete, success)
+			return err
+		}
+	}
+	if success == 1 {
+		if err := runBatchCallback(fields[1]); err != nil {
+			releaseBatchCallbacks(id, 0, success)
+			return err
+		}
+	}
+
+	// A child counts as one job of its parent, which fails when the child
+	// completes with failures and succeeds when the child succeeds.
+	if parent, _ := fields[2].(string); parent != "" {
+		if success == 1 {
+			return finishBatchJob(parent, batchChildID(id), true)
+		}
+		return finishBatchJob(parent, batchChildID(id), false)
+	}
+
+	return nil
+}
+
+// releaseBatchCallbacks gives back claimed callbacks that weren't enqueued,
+// so they're claimed again when the next job finishes or the batch is
+// committed again.
+func releaseBatchCallbacks(id string, complete, success int64) {
+	var claims []string
+	if complete == 1 {
+		claims = append(claims, "completed_at")
+	}
+	if success == 1 {
+		claims = append(claims, "succeeded_at")
+	}
+
+	if err := Config.Client.HDel(batchKey(id), claims...).Err(); err != nil {
+		Logger.Println("ERR: Couldn't release the callbacks of batch", id, ":", err)
+	}
+}
+
+func runBatchCallback(field interface{}) error {
+	encoded, _ := field.(string)
+	if encoded == "" {
+		return nil
+	}
+
+	callback := &batchCallback{}
+	if err := json.Unmarshal([]byte(encoded), callback); err != nil {
+		return err
+	}
+
+	_, err := EnqueueWithOptions(callback.Queue, callback.Class, callback.Args, EnqueueOptions{})
+	return err
+}
+
+// BatchMiddleware records the outcome of jobs enqueued through a Batch.
+// It's one of the default middlewares, and should run outside
+// RetryMiddleware.
+func BatchMiddleware(queue string, next JobFunc) JobFunc {
+	return func(message *Msg) (err error) {
+		id, _ := message.Get("bid").String()
+		if id == "" {
+			return next(message)
+		}
+
+		before := RetrySnapshot(message)
+
+		defer func() {
+			e := recover()
+			if e != nil {
+				err = fmt.Errorf("%v", e)
+			}
+
+			succeeded := JobOutcome(message, before, err) == StatusComplete
+			if batchErr := finishBatchJob(id, message.Jid(), succeeded); batchErr != nil {
+				Logger.Println("ERR: Couldn't update batch", id, "for", message.Jid(), ":", batchErr)
+			}
+
+			if e != nil {
+				panic(e)
+			}
+		}()
+
+		return next(message)
+	}
+}
+
+func batchTime(value string) time.Time {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return secondsToTime(seconds)
+}
+
+func batchChildID(id string) string {
+	return "batch:" + id
+}
+
+// Batch keys share a hash tag, so scripts can use them together on a
+// cluster.
+func batchKey(id string) string {
+	return Config.Namespace + BATCH_KEY + ":{" + id + "}"
+}
+
+func batchPendingKey(id string) string {
+	return batchKey(id) + ":pending"
+}
+
+func batchFailedKey(id string) string {
+	return batchKey(id) + ":failed"
+}
@@ -0,0 +1,186 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runBatchJob runs a job from a queue through BatchMiddleware and
+// RetryMiddleware, like a worker would.
+func runBatchJob(t *testing.T, queue string, fail bool) *Msg {
+	payload, err := Config.Client.RPop(Config.queueKey(queue)).Result()
+	assert.NoError(t, err)
+
+	message, _ := NewMsg(payload)
+	NewMiddlewares(BatchMiddleware, RetryMiddleware).build(queue, func(message *Msg) error {
+		if fail {
+			return errors.New("AHHHH")
+		}
+		return nil
+	})(message)
+
+	return message
+}
+
+func callbacks(queue string) []string {
+	jobs, _ := Config.Client.LRange(Config.queueKey(queue), 0, -1).Result()
+
+	var classes []string
+	for _, job := range jobs {
+		message, _ := NewMsg(job)
+		class, _ := message.Get("class").String()
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+func TestBatch(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	batch := NewBatch("import")
+	batch.OnComplete("callbacks", "Complete", []string{batch.ID})
+	batch.OnSuccess("callbacks", "Success", []string{batch.ID})
+
+	for i := 0; i < 3; i++ {
+		_, err := batch.Enqueue("batchQueue", "Add", []int{i}, EnqueueOptions{Retry: true})
+		assert.NoError(t, err)
+	}
+
+	status, err := batch.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "import", status.Description)
+	assert.Equal(t, int64(3), status.Total)
+	assert.Equal(t, int64(3), status.Pending)
+
+	//tags jobs with the batch
+	message := runBatchJob(t, "batchQueue", false)
+	id, _ := message.Get("bid").String()
+	assert.Equal(t, batch.ID, id)
+
+	//waits to be committed before running callbacks
+	runBatchJob(t, "batchQueue", true)
+	assert.Equal(t, 0, len(callbacks("callbacks")))
+
+	assert.NoError(t, batch.Commit())
+	assert.Equal(t, 0, len(callbacks("callbacks")))
+
+	//completes once every job has run, even with failures
+	runBatchJob(t, "batchQueue", false)
+
+	status, _ = batch.Status()
+	assert.Equal(t, int64(1), status.Pending)
+	assert.Equal(t, int64(1), status.Failures)
+	assert.True(t, status.Complete())
+	assert.False(t, status.Succeeded())
+	assert.Equal(t, []string{"Complete"}, callbacks("callbacks"))
+
+	//succeeds once failed jobs succeed on retry
+	retries, _ := RetrySet().Page(0, -1)
+	assert.Equal(t, 1, len(retries))
+	assert.NoError(t, RetrySet().RunNow(retries[0]))
+	runBatchJob(t, "batchQueue", false)
+
+	status, _ = batch.Status()
+	assert.Equal(t, int64(0), status.Pending)
+	assert.True(t, status.Succeeded())
+	assert.Equal(t, []string{"Success", "Complete"}, callbacks("callbacks"))
+
+	//doesn't take more jobs once complete
+	_, err = batch.Enqueue("batchQueue", "Add", []int{4}, EnqueueOptions{})
+	assert.Equal(t, ErrBatchComplete, err)
+}
+
+func TestEmptyBatch(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	batch := NewBatch("")
+	batch.OnSuccess("callbacks", "Success", nil)
+	assert.NoError(t, batch.Commit())
+
+	//runs callbacks straight away
+	assert.Equal(t, []string{"Success"}, callbacks("callbacks"))
+}
+
+func TestBatchEnqueueErrors(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	rejecting := true
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			if rejecting && data.Class != "Add" {
+				return errors.New("rejected")
+			}
+			return next(data)
+		}
+	})
+
+	batch := NewBatch("")
+	batch.OnSuccess("callbacks", "Success", nil)
+
+	//doesn't count jobs that weren't enqueued
+	_, err := batch.Enqueue("batchQueue", "Add", nil, EnqueueOptions{})
+	assert.NoError(t, err)
+	_, err = batch.Enqueue("batchQueue", "Reject", nil, EnqueueOptions{})
+	assert.Error(t, err)
+
+	status, _ := batch.Status()
+	assert.Equal(t, int64(1), status.Total)
+	assert.Equal(t, int64(1), status.Pending)
+
+	//keeps callbacks that couldn't be enqueued for the next commit
+	assert.NoError(t, batch.Commit())
+	runBatchJob(t, "batchQueue", false)
+
+	status, _ = batch.Status()
+	assert.False(t, status.Succeeded())
+	assert.Equal(t, 0, len(callbacks("callbacks")))
+
+	rejecting = false
+	assert.NoError(t, batch.Commit())
+
+	status, _ = batch.Status()
+	assert.True(t, status.Succeeded())
+	assert.Equal(t, []string{"Success"}, callbacks("callbacks"))
+}
+
+func TestNestedBatch(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	parent := NewBatch("parent")
+	parent.OnSuccess("callbacks", "ParentSuccess", nil)
+	parent.Enqueue("batchQueue", "Add", []int{1}, EnqueueOptions{})
+
+	child := parent.Child("child")
+	child.OnSuccess("callbacks", "ChildSuccess", nil)
+	child.Enqueue("batchQueue", "Add", []int{2}, EnqueueOptions{})
+
+	assert.NoError(t, child.Commit())
+	assert.NoError(t, parent.Commit())
+
+	status, _ := parent.Status()
+	assert.Equal(t, int64(2), status.Pending)
+
+	//waits for the child batch
+	runBatchJob(t, "batchQueue", false)
+	assert.Equal(t, 0, len(callbacks("callbacks")))
+
+	//adds jobs to a running batch
+	reopened, err := OpenBatch(child.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "child", reopened.Description)
+	reopened.Enqueue("batchQueue", "Add", []int{3}, EnqueueOptions{})
+
+	runBatchJob(t, "batchQueue", false)
+	assert.Equal(t, 0, len(callbacks("callbacks")))
+
+	runBatchJob(t, "batchQueue", false)
+	assert.Equal(t, []string{"ParentSuccess", "ChildSuccess"}, callbacks("callbacks"))
+
+	_, err = OpenBatch("missing")
+	assert.Error(t, err)
+}
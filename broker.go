@@ -0,0 +1,115 @@
+package workers
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// These are variables for testing reasons
+var brokerBackoffMin = 100 * time.Millisecond
+var brokerBackoffMax = 30 * time.Second
+var brokerLogInterval = 30 * time.Second
+
+var broker = &brokerState{}
+
+// brokerState tracks whether redis is currently reachable, so an outage can
+// be reported through Stats and health checks.
+type brokerState struct {
+	sync.RWMutex
+	unavailableSince time.Time
+	lastError        error
+}
+
+func (b *brokerState) failed(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.unavailableSince.IsZero() {
+		b.unavailableSince = time.Now()
+	}
+	b.lastError = err
+}
+
+func (b *brokerState) succeeded() {
+	b.Lock()
+	defer b.Unlock()
+	b.unavailableSince = time.Time{}
+	b.lastError = nil
+}
+
+func (b *brokerState) status() (available bool, since time.Time, err error) {
+	b.RLock()
+	defer b.RUnlock()
+	return b.unavailableSince.IsZero(), b.unavailableSince, b.lastError
+}
+
+// BrokerAvailable reports whether the last redis call made by the fetch or
+// scheduler loops succeeded.
+func BrokerAvailable() bool {
+	available, _, _ := broker.status()
+	return available
+}
+
+// backoff computes exponentially growing delays, randomizing the upper half
+// of each delay so processes don't reconnect in lockstep.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff() *backoff {
+	return &backoff{min: brokerBackoffMin, max: brokerBackoffMax}
+}
+
+func (b *backoff) next() time.Duration {
+	ceiling := b.max
+	if b.attempt < 32 {
+		if d := b.min << b.attempt; d > 0 && d < b.max {
+			ceiling = d
+		}
+	}
+	b.attempt++
+
+	half := ceiling / 2
+	if half <= 0 {
+		return ceiling
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// logLimiter logs at most once per interval, reporting how many messages
+// were suppressed in between, so an outage doesn't flood the logs.
+type logLimiter struct {
+	sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+func newLogLimiter() *logLimiter {
+	return &logLimiter{interval: brokerLogInterval}
+}
+
+func (l *logLimiter) Println(v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.last.IsZero() && time.Since(l.last) < l.interval {
+		l.suppressed++
+		return
+	}
+
+	if l.suppressed > 0 {
+		v = append(v, fmt.Sprintf("(%d similar messages suppressed)", l.suppressed))
+	}
+	Logger.Println(v...)
+
+	l.last = time.Now()
+	l.suppressed = 0
+}
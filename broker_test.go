@@ -0,0 +1,91 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Println(v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintln(v...))
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: 100 * time.Millisecond, max: time.Second}
+
+	//grows exponentially with jitter
+	d := b.next()
+	assert.True(t, d >= 50*time.Millisecond && d < 100*time.Millisecond)
+	d = b.next()
+	assert.True(t, d >= 100*time.Millisecond && d < 200*time.Millisecond)
+	d = b.next()
+	assert.True(t, d >= 200*time.Millisecond && d < 400*time.Millisecond)
+
+	//is capped at max
+	for i := 0; i < 100; i++ {
+		d = b.next()
+	}
+	assert.True(t, d >= 500*time.Millisecond && d < time.Second)
+
+	//starts over when reset
+	b.reset()
+	d = b.next()
+	assert.True(t, d < 100*time.Millisecond)
+}
+
+func TestLogLimiter(t *testing.T) {
+	oldLogger := Logger
+	defer func() {
+		Logger = oldLogger
+	}()
+	logger := &recordingLogger{}
+	Logger = logger
+
+	l := &logLimiter{interval: 50 * time.Millisecond}
+
+	l.Println("down")
+	l.Println("down")
+	l.Println("down")
+	assert.Equal(t, []string{"down\n"}, logger.lines)
+
+	time.Sleep(60 * time.Millisecond)
+
+	l.Println("down")
+	assert.Equal(t, []string{"down\n", "down (2 similar messages suppressed)\n"}, logger.lines)
+}
+
+func TestBrokerHealth(t *testing.T) {
+	defer broker.succeeded()
+
+	recorder := httptest.NewRecorder()
+	Health(recorder, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, BrokerAvailable())
+
+	broker.failed(errors.New("connection refused"))
+
+	recorder = httptest.NewRecorder()
+	Health(recorder, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.False(t, BrokerAvailable())
+
+	_, since, err := broker.status()
+	assert.False(t, since.IsZero())
+	assert.EqualError(t, err, "connection refused")
+
+	broker.succeeded()
+	assert.True(t, BrokerAvailable())
+}
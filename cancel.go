@@ -0,0 +1,232 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// These are variables for testing reasons
+var cancelTTL = 24 * time.Hour
+var cancelledCheckInterval = 1 * time.Second
+
+// cancelledOverlap is how far back checks for cancelled jobs go before the
+// previous check, so jobs cancelled by processes whose clocks are a little
+// behind aren't missed.
+const cancelledOverlap = time.Minute
+
+// ErrCancelled is returned for jobs cancelled with Cancel while running.
+// Cancelled jobs are neither retried nor counted as failed.
+var ErrCancelled = errors.New("job cancelled")
+
+// Cancel stops a job. A job that's waiting in a queue, or in the scheduled
+// or retry sets, is skipped when it's fetched, as long as that happens
+// within 24 hours. A job that's running on any process has its Context
+// cancelled; it's up to the job to watch message.Context().Done() and stop.
+func Cancel(jid string) error {
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+
+	pipe := rc.Pipeline()
+	pipe.ZAdd(cancelledKey(), redis.Z{Score: now, Member: jid})
+	pipe.ZRemRangeByScore(cancelledKey(), "-inf", fmt.Sprint(now-cancelTTL.Seconds()))
+	pipe.Expire(cancelledKey(), cancelTTL)
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	cancelled.add(jid, now)
+
+	return rc.Publish(Config.Namespace+CANCEL_CHANNEL, jid).Err()
+}
+
+// IsCancelled reports whether Cancel has been called for a job.
+func IsCancelled(jid string) (bool, error) {
+	at, err := Config.Client.ZScore(cancelledKey(), jid).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil && at > nowToSecondsWithNanoPrecision()-cancelTTL.Seconds(), err
+}
+
+func cancelledKey() string {
+	return Config.Namespace + CANCELLED_KEY
+}
+
+// cancelledJobs is this process's copy of the jobs cancelled in the last
+// cancelTTL, so fetchers don't ask redis about every job. The canceller
+// adds jobs as they're cancelled, and fetchers catch up with redis at most
+// once every cancelledCheckInterval, for jobs cancelled while it wasn't
+// listening.
+type cancelledJobs struct {
+	sync.Mutex
+	jids      map[string]float64
+	checkedAt time.Time
+	since     float64
+}
+
+var cancelled = newCancelledJobs()
+
+func newCancelledJobs() *cancelledJobs {
+	return &cancelledJobs{jids: make(map[string]float64)}
+}
+
+func (c *cancelledJobs) add(jid string, at float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.jids[jid] = at
+}
+
+// has reports whether a job has been cancelled.
+func (c *cancelledJobs) has(jid string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if time.Since(c.checkedAt) >= cancelledCheckInterval {
+		c.check()
+	}
+
+	_, found := c.jids[jid]
+	return found
+}
+
+// check loads the jobs cancelled since the previous check, and forgets the
+// ones cancelled more than cancelTTL ago.
+func (c *cancelledJobs) check() {
+	now := nowToSecondsWithNanoPrecision()
+	expired := now - cancelTTL.Seconds()
+
+	min := c.since
+	if min < expired {
+		min = expired
+	}
+
+	entries, err := Config.Client.ZRangeByScoreWithScores(cancelledKey(), redis.ZRangeBy{
+		Min: fmt.Sprint(min),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if jid, ok := entry.Member.(string); ok {
+			c.jids[jid] = entry.Score
+		}
+	}
+	for jid, at := range c.jids {
+		if at <= expired {
+			delete(c.jids, jid)
+		}
+	}
+
+	c.checkedAt = time.Now()
+	c.since = now - cancelledOverlap.Seconds()
+}
+
+// reset forgets every job, for a new configuration.
+func (c *cancelledJobs) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.jids = make(map[string]float64)
+	c.checkedAt = time.Time{}
+	c.since = 0
+}
+
+// finishSkipped records the outcome of a job that was cancelled or expired
+// before it ran, for the middlewares that would have recorded it.
+func finishSkipped(message *Msg, status string, reason error) {
+	jid := message.Jid()
+
+	if message.tracked() {
+		updateStatus(jid, map[string]interface{}{"status": status})
+	}
+
+	if stored, _ := message.Get("store_result").Bool(); stored {
+		result := &JobResult{
+			Jid:        jid,
+			Status:     status,
+			Error:      reason.Error(),
+			FinishedAt: nowToSecondsWithNanoPrecision(),
+		}
+		if err := storeResult(result, nil); err != nil {
+			Logger.Println("ERR: Couldn't store result of", jid, ":", err)
+		}
+	}
+
+	if id, _ := message.Get("bid").String(); id != "" {
+		if err := finishBatchJob(id, jid, false); err != nil {
+			Logger.Println("ERR: Couldn't update batch", id, "for", jid, ":", err)
+		}
+	}
+
+	if id, _ := message.Get("wfid").String(); id != "" {
+		name, _ := message.Get("wfstep").String()
+		if err := finishWorkflowStep(id, name, false, reason); err != nil {
+			Logger.Println("ERR: Couldn't update workflow", id, "for step", name, ":", err)
+		}
+	}
+}
+
+// canceller listens for jobs cancelled on any process, to cancel them if
+// they're running here.
+type canceller struct {
+	pubsub *redis.PubSub
+}
+
+func (c *canceller) start() {
+	// Wait for the subscription, so jobs cancelled after Start returns
+	// aren't missed.
+	if _, err := c.pubsub.Receive(); err != nil {
+		Logger.Println("ERR: couldn't subscribe to cancelled jobs:", err)
+	}
+
+	go (func() {
+		for message := range c.pubsub.Channel() {
+			cancelled.add(message.Payload, nowToSecondsWithNanoPrecision())
+			cancelRunning(message.Payload)
+		}
+	})()
+}
+
+func (c *canceller) quit() {
+	if err := c.pubsub.Close(); err != nil {
+		Logger.Println("ERR: couldn't unsubscribe from cancelled jobs:", err)
+	}
+}
+
+// cancelRunning cancels the job with the given JID if it's running on this
+// process.
+func cancelRunning(jid string) {
+	for _, m := range currentManagers() {
+		for _, running := range m.running() {
+			if running.message.Jid() == jid {
+				Logger.Println("cancelling", running.queue, "JID-"+jid)
+				running.message.cancel()
+			}
+		}
+	}
+}
+
+func newCanceller() *canceller {
+	return &canceller{Config.Client.Subscribe(Config.Namespace + CANCEL_CHANNEL)}
+}
+
+// withCancel gives a message a Context that carries its metadata and is
+// cancelled by Cancel, and returns the function to release it once the job
+// is done.
+func (m *Msg) withCancel() context.CancelFunc {
+	ctx := context.Background()
+	if md := m.Metadata(); len(md) > 0 {
+		ctx = WithMetadata(ctx, md)
+	}
+
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	return m.cancel
+}
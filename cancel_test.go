@@ -0,0 +1,169 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelQueuedJob(t *testing.T) {
+	setupTestConfig()
+	rc := Config.Client
+
+	jid, _ := Enqueue("cancelQueue1", "Add", []int{1, 2})
+	other, _ := Enqueue("cancelQueue1", "Add", []int{3, 4})
+
+	assert.NoError(t, Cancel(jid))
+
+	cancelled, err := IsCancelled(jid)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	cancelled, _ = IsCancelled(other)
+	assert.False(t, cancelled)
+
+	ttl, _ := rc.TTL("cancelled").Result()
+	assert.True(t, ttl > 0)
+
+	//skips cancelled jobs when they're fetched
+	fetch := buildFetch("cancelQueue1")
+
+	fetch.Ready() <- true
+	fetch.Ready() <- true
+	message := <-fetch.Messages()
+	assert.Equal(t, other, message.Jid())
+
+	inprogress, _ := rc.LRange("queue:cancelQueue1:1:inprogress", 0, -1).Result()
+	assert.Equal(t, []string{message.OriginalJson()}, inprogress)
+
+	count, _ := rc.Get("stat:cancelled").Result()
+	assert.Equal(t, "1", count)
+
+	fetch.Close()
+}
+
+func TestCancelledJobsAreCheckedLocally(t *testing.T) {
+	setupTestConfig()
+	rc := Config.Client
+
+	defer func(interval time.Duration) { cancelledCheckInterval = interval }(cancelledCheckInterval)
+	cancelledCheckInterval = time.Hour
+
+	now := nowToSecondsWithNanoPrecision()
+	rc.ZAdd("cancelled", redis.Z{Score: now, Member: "1"})
+	rc.ZAdd("cancelled", redis.Z{Score: now - cancelTTL.Seconds() - 1, Member: "2"})
+
+	//loads jobs cancelled by other processes in the last day
+	assert.True(t, cancelled.has("1"))
+	assert.False(t, cancelled.has("2"))
+
+	found, _ := IsCancelled("2")
+	assert.False(t, found)
+
+	//doesn't ask redis again until the check interval is up
+	rc.ZAdd("cancelled", redis.Z{Score: now, Member: "3"})
+	assert.False(t, cancelled.has("3"))
+
+	cancelledCheckInterval = 0
+	assert.True(t, cancelled.has("3"))
+
+	//jobs cancelled here are known straight away
+	cancelledCheckInterval = time.Hour
+	assert.NoError(t, Cancel("4"))
+	assert.True(t, cancelled.has("4"))
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	started := make(chan bool)
+	finished := make(chan error)
+	Process("cancelQueue2", func(message *Msg) error {
+		started <- true
+		select {
+		case <-message.Context().Done():
+			finished <- message.Context().Err()
+			return message.Context().Err()
+		case <-time.After(5 * time.Second):
+			finished <- nil
+			return nil
+		}
+	}, 1)
+	defer forgetManager("cancelQueue2")
+
+	Start()
+	defer Quit()
+
+	jid, _ := EnqueueWithOptions("cancelQueue2", "Add", []int{1, 2}, EnqueueOptions{Retry: true})
+	<-started
+
+	//cancels the job's context on the process running it
+	assert.NoError(t, Cancel(jid))
+	assert.Error(t, <-finished)
+
+	//doesn't retry it or count it as failed
+	var count string
+	for i := 0; i < 100 && count == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		count, _ = rc.Get("prod:stat:cancelled").Result()
+	}
+	assert.Equal(t, "1", count)
+
+	failed, _ := rc.Exists("prod:stat:failed").Result()
+	assert.Equal(t, int64(0), failed)
+
+	retries, _ := rc.ZCard("prod:" + RETRY_KEY).Result()
+	assert.Equal(t, int64(0), retries)
+}
+
+func TestRetryMiddlewareSkipsCancelledJobs(t *testing.T) {
+	setupTestConfig()
+
+	message, _ := NewMsg("{\"jid\":\"2\",\"retry\":true}")
+	done := message.withCancel()
+	defer done()
+	message.cancel()
+
+	err := RetryMiddleware("myqueue", func(message *Msg) error {
+		return message.Context().Err()
+	})(message)
+
+	assert.Equal(t, ErrCancelled, err)
+	assert.True(t, message.ack)
+
+	retries, _ := Config.Client.ZCard(RETRY_KEY).Result()
+	assert.Equal(t, int64(0), retries)
+}
+
+func TestCancelledJobsFinish(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	batch := NewBatch("")
+	batch.OnComplete("callbacks", "Complete", nil)
+	jid, _ := batch.Enqueue("cancelQueue3", "Add", []int{1}, EnqueueOptions{
+		TrackStatus: true,
+		StoreResult: true,
+	})
+	batch.Commit()
+
+	assert.NoError(t, Cancel(jid))
+
+	fetch := buildFetch("cancelQueue3")
+	fetch.Ready() <- true
+	fetch.Ready() <- true
+
+	//records cancelled jobs as done without running them
+	status, _ := Status(jid)
+	assert.Equal(t, StatusCancelled, status.Status)
+
+	result, _ := Result(jid)
+	assert.True(t, result.Failed())
+
+	batchStatus, _ := batch.Status()
+	assert.True(t, batchStatus.Complete())
+
+	fetch.Close()
+}
@@ -0,0 +1,515 @@
+// Command workersctl inspects and administers go-workers queues, jobs and
+// processes through redis.
+//
+// Connection settings are read from flags, falling back to the
+// WORKERS_REDIS_URL, WORKERS_REDIS_ADDR, WORKERS_REDIS_PASSWORD and
+// WORKERS_NAMESPACE environment variables.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/go-workers2"
+)
+
+const usage = `Usage: workersctl [flags] <command> [arguments]
+
+Commands:
+  stats                            show processed/failed counters and set sizes
+  queues                           list queues with size, latency and paused state
+  jobs <queue> [offset] [count]    list jobs waiting in a queue
+  processes                        list running processes
+  busy                             list jobs being processed
+  list <set> [offset] [count]      list jobs in the retry, scheduled or dead set
+  show <set> <jid>                 print a job from a set as JSON
+  retry <set> <jid>|all            push jobs from a set onto their queues now
+  delete <set> <jid>|all           delete jobs from a set
+  status <jid>                     show the status of a job enqueued with status tracking
+  cancel <jid>                     cancel a queued, scheduled or running job
+  enqueue <json>|-                 enqueue a job described as JSON, e.g.
+                                   {"queue":"default","class":"Add","args":[1,2],"retry":true}
+  pause <queue>                    stop all processes fetching from a queue
+  resume <queue>                   resume a paused queue
+  quiet <identity>|all             stop processes fetching new jobs
+  stop <identity>|all              make processes finish running jobs and exit
+  dump <identity>|all              make processes log the jobs they're running
+  concurrency <identity>|all <queue> <workers>
+                                   change how many workers process a queue
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("workersctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	url := flags.String("url", os.Getenv("WORKERS_REDIS_URL"), "redis:// or rediss:// URL")
+	addr := flags.String("addr", envOr("WORKERS_REDIS_ADDR", "localhost:6379"), "redis server address")
+	password := flags.String("password", os.Getenv("WORKERS_REDIS_PASSWORD"), "redis password")
+	database := flags.Int("db", 0, "redis database")
+	namespace := flags.String("namespace", os.Getenv("WORKERS_NAMESPACE"), "namespace used by the workers")
+	sentinels := flags.String("sentinels", "", "comma separated sentinel addresses, instead of -addr")
+	master := flags.String("master", "", "sentinel master name")
+	cluster := flags.String("cluster", "", "comma separated cluster addresses, instead of -addr")
+
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	options := workers.Options{
+		ProcessID: "workersctl",
+		Namespace: *namespace,
+		Password:  *password,
+		Database:  *database,
+		URL:       *url,
+	}
+	switch {
+	case *cluster != "":
+		options.ClusterAddrs = *cluster
+	case *sentinels != "":
+		options.SentinelAddrs = *sentinels
+		options.RedisMasterName = *master
+	default:
+		options.ServerAddr = *addr
+	}
+
+	if err := workers.Configure(options); err != nil {
+		fail(err)
+	}
+
+	if err := run(flags.Arg(0), flags.Args()[1:]); err != nil {
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintln(os.Stderr, "workersctl:", err)
+			os.Exit(2)
+		}
+		fail(err)
+	}
+}
+
+// usageError is returned for commands given the wrong arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func run(command string, args []string) error {
+	switch command {
+	case "stats":
+		return stats()
+	case "queues":
+		return queues()
+	case "jobs":
+		if len(args) < 1 {
+			return usageError("jobs requires a queue")
+		}
+		offset, count, err := pagination(args[1:])
+		if err != nil {
+			return err
+		}
+		return jobs(args[0], offset, count)
+	case "processes":
+		return processes()
+	case "busy":
+		return busy()
+	case "list":
+		set, err := sortedSet(args)
+		if err != nil {
+			return err
+		}
+		offset, count, err := pagination(args[1:])
+		if err != nil {
+			return err
+		}
+		return list(set, offset, count)
+	case "show":
+		set, jid, err := setAndJid(args)
+		if err != nil {
+			return err
+		}
+		entry, err := set.FindJob(jid)
+		if err != nil {
+			return err
+		}
+		fmt.Println(entry.OriginalJson())
+		return nil
+	case "retry":
+		set, jid, err := setAndJid(args)
+		if err != nil {
+			return err
+		}
+		return eachEntry(set, jid, "retried", set.RunNow)
+	case "delete":
+		set, jid, err := setAndJid(args)
+		if err != nil {
+			return err
+		}
+		return eachEntry(set, jid, "deleted", set.Delete)
+	case "status":
+		if len(args) < 1 {
+			return usageError("status requires a jid")
+		}
+		status, err := workers.Status(args[0])
+		if err != nil {
+			return err
+		}
+		bytes, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+		return nil
+	case "cancel":
+		if len(args) < 1 {
+			return usageError("cancel requires a jid")
+		}
+		return workers.Cancel(args[0])
+	case "enqueue":
+		if len(args) < 1 {
+			return usageError("enqueue requires a JSON job, or - to read it from stdin")
+		}
+		return enqueue(args[0])
+	case "pause", "resume":
+		if len(args) < 1 {
+			return usageError(command + " requires a queue")
+		}
+		queue := workers.NewQueue(args[0])
+		if command == "pause" {
+			return queue.Pause()
+		}
+		return queue.Resume()
+	case "quiet":
+		return signal(args, workers.QuietProcess)
+	case "stop":
+		return signal(args, workers.StopProcess)
+	case "dump":
+		return signal(args, workers.DumpProcess)
+	case "concurrency":
+		if len(args) < 3 {
+			return usageError("concurrency requires a process identity, a queue and a number of workers")
+		}
+		concurrency, err := strconv.Atoi(args[2])
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid number of workers %q", args[2]))
+		}
+		return signal(args, func(identity string) error {
+			return workers.SetProcessConcurrency(identity, args[1], concurrency)
+		})
+	}
+
+	return usageError(fmt.Sprintf("unknown command %q, run workersctl -h for usage", command))
+}
+
+func stats() error {
+	totals, err := workers.StatsTotals()
+	if err != nil {
+		return err
+	}
+
+	processes, err := workers.Processes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Processed:\t%d\n", totals.Processed)
+	fmt.Fprintf(w, "Failed:\t%d\n", totals.Failed)
+	fmt.Fprintf(w, "Cancelled:\t%d\n", totals.Cancelled)
+	fmt.Fprintf(w, "Expired:\t%d\n", totals.Expired)
+	fmt.Fprintf(w, "Scheduled:\t%d\n", totals.Scheduled)
+	fmt.Fprintf(w, "Retries:\t%d\n", totals.Retries)
+	fmt.Fprintf(w, "Dead:\t%d\n", totals.Dead)
+	fmt.Fprintf(w, "Quarantined:\t%d\n", totals.Quarantined)
+	fmt.Fprintf(w, "Processes:\t%d\n", len(processes))
+
+	names := make([]string, 0, len(totals.Enqueued))
+	for name := range totals.Enqueued {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "Enqueued (%s):\t%d\n", name, totals.Enqueued[name])
+	}
+
+	return w.Flush()
+}
+
+func queues() error {
+	queues, err := workers.Queues()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tSIZE\tLATENCY\tPAUSED")
+	for _, queue := range queues {
+		size, err := queue.Size()
+		if err != nil {
+			return err
+		}
+		latency, err := queue.Latency()
+		if err != nil {
+			return err
+		}
+		paused, err := queue.Paused()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%t\n", queue.Name(), size, latency.Truncate(time.Millisecond), paused)
+	}
+
+	return w.Flush()
+}
+
+func jobs(name string, offset, count int64) error {
+	messages, err := workers.NewQueue(name).Page(offset, count)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JID\tCLASS\tENQUEUED AT\tARGS")
+	for _, message := range messages {
+		class, _ := message.Get("class").String()
+		enqueuedAt, _ := message.Get("enqueued_at").Float64()
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", message.Jid(), class, formatTime(enqueuedAt), message.Args().ToJson())
+	}
+
+	return w.Flush()
+}
+
+func processes() error {
+	processes, err := workers.Processes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IDENTITY\tPID\tBUSY\tCONCURRENCY\tQUEUES\tQUIET\tLAST BEAT")
+	for _, process := range processes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%t\t%s\n",
+			process.Identity, process.Pid, process.Busy, process.Concurrency,
+			strings.Join(process.Queues, ","), process.Quiet,
+			time.Since(process.Beat).Truncate(time.Second))
+	}
+
+	return w.Flush()
+}
+
+func busy() error {
+	jobs, err := workers.RunningJobs()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROCESS\tQUEUE\tJID\tCLASS\tRUNNING FOR\tARGS")
+	for _, job := range jobs {
+		class, _ := job.Get("class").String()
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			job.Process, job.Queue, job.Jid(), class,
+			time.Since(job.RunAt).Truncate(time.Second), job.Args().ToJson())
+	}
+
+	return w.Flush()
+}
+
+func list(set *workers.SortedSet, offset, count int64) error {
+	entries, err := set.Page(offset, count)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JID\tCLASS\tQUEUE\tAT\tRETRIES\tERROR")
+	for _, entry := range entries {
+		class, _ := entry.Get("class").String()
+		queue, _ := entry.Get("queue").String()
+		retries, _ := entry.Get("retry_count").Int()
+		errorMessage, _ := entry.Get("error_message").String()
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			entry.Jid(), class, queue, entry.At.Format(time.RFC3339), retries, errorMessage)
+	}
+
+	return w.Flush()
+}
+
+func eachEntry(set *workers.SortedSet, jid, done string, fn func(*workers.SortedEntry) error) error {
+	var entries []*workers.SortedEntry
+	if jid == "all" {
+		var err error
+		if entries, err = set.Page(0, -1); err != nil {
+			return err
+		}
+	} else {
+		entry, err := set.FindJob(jid)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if err := fn(entry); err == nil {
+			count++
+		} else if err != workers.ErrJobNotFound {
+			return err
+		}
+	}
+
+	fmt.Println(count, "jobs", done)
+	return nil
+}
+
+func enqueue(source string) error {
+	payload := []byte(source)
+	if source == "-" {
+		var err error
+		if payload, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	var job struct {
+		Queue string      `json:"queue"`
+		Class string      `json:"class"`
+		Args  interface{} `json:"args"`
+		workers.EnqueueOptions
+	}
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+
+	if job.Queue == "" || job.Class == "" {
+		return errors.New("jobs require a queue and a class")
+	}
+	if job.Args == nil {
+		job.Args = []interface{}{}
+	}
+
+	jid, err := workers.EnqueueWithOptions(job.Queue, job.Class, job.Args, job.EnqueueOptions)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(jid)
+	return nil
+}
+
+func signal(args []string, fn func(identity string) error) error {
+	if len(args) < 1 {
+		return usageError("requires a process identity, or all")
+	}
+
+	identities := []string{args[0]}
+	if args[0] == "all" {
+		processes, err := workers.Processes()
+		if err != nil {
+			return err
+		}
+
+		identities = identities[:0]
+		for _, process := range processes {
+			identities = append(identities, process.Identity)
+		}
+	}
+
+	for _, identity := range identities {
+		if err := fn(identity); err != nil {
+			return err
+		}
+		fmt.Println("signalled", identity)
+	}
+
+	return nil
+}
+
+func sortedSet(args []string) (*workers.SortedSet, error) {
+	if len(args) < 1 {
+		return nil, usageError("requires a set: retry, scheduled or dead")
+	}
+
+	switch args[0] {
+	case "retry", "retries":
+		return workers.RetrySet(), nil
+	case "scheduled":
+		return workers.ScheduledSet(), nil
+	case "dead":
+		return workers.DeadSet(), nil
+	}
+
+	return nil, usageError(fmt.Sprintf("unknown set %q, expected retry, scheduled or dead", args[0]))
+}
+
+func setAndJid(args []string) (*workers.SortedSet, string, error) {
+	set, err := sortedSet(args)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(args) < 2 {
+		return nil, "", usageError("requires a jid")
+	}
+
+	return set, args[1], nil
+}
+
+// pagination parses the optional offset and count arguments, showing 25 jobs
+// from the start by default.
+func pagination(args []string) (offset, count int64, err error) {
+	count = 25
+	if len(args) > 2 {
+		return 0, 0, usageError("expected at most an offset and a count")
+	}
+	if len(args) > 0 {
+		if offset, err = strconv.ParseInt(args[0], 10, 64); err != nil || offset < 0 {
+			return 0, 0, usageError(fmt.Sprintf("invalid offset %q, expected a number of jobs to skip", args[0]))
+		}
+	}
+	if len(args) > 1 {
+		if count, err = strconv.ParseInt(args[1], 10, 64); err != nil || count < 1 {
+			return 0, 0, usageError(fmt.Sprintf("invalid count %q, expected a number of jobs to show", args[1]))
+		}
+	}
+	return offset, count, nil
+}
+
+func formatTime(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return time.Unix(int64(seconds), 0).Format(time.RFC3339)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "workersctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/digitalocean/go-workers2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		offset int64
+		count  int64
+		err    string
+	}{
+		{"defaults", nil, 0, 25, ""},
+		{"offset", []string{"50"}, 50, 25, ""},
+		{"offset and count", []string{"10", "5"}, 10, 5, ""},
+		{"invalid offset", []string{"ten"}, 0, 0, `invalid offset "ten", expected a number of jobs to skip`},
+		{"negative offset", []string{"-1"}, 0, 0, `invalid offset "-1", expected a number of jobs to skip`},
+		{"invalid count", []string{"0", "all"}, 0, 0, `invalid count "all", expected a number of jobs to show`},
+		{"zero count", []string{"0", "0"}, 0, 0, `invalid count "0", expected a number of jobs to show`},
+		{"extra arguments", []string{"0", "10", "20"}, 0, 0, "expected at most an offset and a count"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offset, count, err := pagination(test.args)
+			if test.err != "" {
+				assert.Equal(t, usageError(test.err), err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.offset, offset)
+			assert.Equal(t, test.count, count)
+		})
+	}
+}
+
+func TestSetAndJid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		set  *workers.SortedSet
+		jid  string
+		err  string
+	}{
+		{"retry", []string{"retry", "abc"}, workers.RetrySet(), "abc", ""},
+		{"retries", []string{"retries", "abc"}, workers.RetrySet(), "abc", ""},
+		{"scheduled", []string{"scheduled", "abc"}, workers.ScheduledSet(), "abc", ""},
+		{"dead", []string{"dead", "all"}, workers.DeadSet(), "all", ""},
+		{"no set", nil, nil, "", "requires a set: retry, scheduled or dead"},
+		{"unknown set", []string{"queued", "abc"}, nil, "", `unknown set "queued", expected retry, scheduled or dead`},
+		{"no jid", []string{"dead"}, nil, "", "requires a jid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, jid, err := setAndJid(test.args)
+			if test.err != "" {
+				assert.Equal(t, usageError(test.err), err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.set, set)
+			assert.Equal(t, test.jid, jid)
+		})
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		err     string
+	}{
+		{"jobs", nil, "jobs requires a queue"},
+		{"jobs", []string{"myqueue", "ten"}, `invalid offset "ten", expected a number of jobs to skip`},
+		{"list", []string{"dead", "0", "-5"}, `invalid count "-5", expected a number of jobs to show`},
+		{"list", []string{"queued"}, `unknown set "queued", expected retry, scheduled or dead`},
+		{"show", []string{"retry"}, "requires a jid"},
+		{"retry", nil, "requires a set: retry, scheduled or dead"},
+		{"status", nil, "status requires a jid"},
+		{"cancel", nil, "cancel requires a jid"},
+		{"enqueue", nil, "enqueue requires a JSON job, or - to read it from stdin"},
+		{"pause", nil, "pause requires a queue"},
+		{"resume", nil, "resume requires a queue"},
+		{"quiet", nil, "requires a process identity, or all"},
+		{"concurrency", []string{"all", "myqueue"}, "concurrency requires a process identity, a queue and a number of workers"},
+		{"concurrency", []string{"all", "myqueue", "many"}, `invalid number of workers "many"`},
+		{"restart", nil, `unknown command "restart", run workersctl -h for usage`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			//fails before talking to redis
+			assert.Equal(t, usageError(test.err), run(test.command, test.args))
+		})
+	}
+}
@@ -1,7 +1,12 @@
 package workers
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,8 +17,14 @@ type config struct {
 	processId    string
 	Namespace    string
 	PollInterval int
-	Client       *redis.Client
-	Fetch        func(queue string) Fetcher
+	AtMostOnce   bool
+
+	AdaptivePollInterval    bool
+	SchedulerLeaderElection bool
+
+	Client  redis.UniversalClient
+	Fetch   func(queue string) Fetcher
+	cluster bool
 }
 
 type Options struct {
@@ -24,10 +35,39 @@ type Options struct {
 	Password     string
 	PoolSize     int
 
-	// Provide one of ServerAddr or (SentinelAddrs + RedisMasterName)
+	// Username authenticates as a Redis 6 ACL user, together with Password.
+	Username string
+
+	// AtMostOnce acknowledges messages before they're processed rather
+	// than after, so a job is never run twice but may be lost on a crash.
+	AtMostOnce bool
+
+	// AdaptivePollInterval treats PollInterval as the average time between
+	// polls of the scheduled and retry sets across all processes, rather
+	// than per process, and randomizes each wait so processes don't poll in
+	// lockstep.
+	AdaptivePollInterval bool
+
+	// SchedulerLeaderElection only lets the process holding a redis lock
+	// poll the scheduled and retry sets. Another process takes over if the
+	// leader stops renewing the lock.
+	SchedulerLeaderElection bool
+
+	// Provide one of ServerAddr, (SentinelAddrs + RedisMasterName) or ClusterAddrs
 	ServerAddr      string
 	SentinelAddrs   string
 	RedisMasterName string
+	ClusterAddrs    string
+
+	// Alternatively, provide a redis:// or rediss:// URL (see ParseURL),
+	// full go-redis options for any of the three modes, or an existing
+	// client to share. These take precedence over the fields above, in
+	// reverse order.
+	URL             string
+	RedisOptions    *redis.Options
+	FailoverOptions *redis.FailoverOptions
+	ClusterOptions  *redis.ClusterOptions
+	Client          redis.UniversalClient
 }
 
 var Config *config
@@ -47,42 +87,224 @@ func Configure(options Options) error {
 		options.PollInterval = 15
 	}
 
-	redisIdleTimeout := 240 * time.Second
-
-	var rc *redis.Client
-	if options.ServerAddr != "" {
-		rc = redis.NewClient(&redis.Options{
-			IdleTimeout: redisIdleTimeout,
-			Password:    options.Password,
-			DB:          options.Database,
-			PoolSize:    options.PoolSize,
-			Addr:        options.ServerAddr,
-		})
-	} else if options.SentinelAddrs != "" {
-		if options.RedisMasterName == "" {
-			return errors.New("Sentinel configuration requires a master name")
-		}
-
-		rc = redis.NewFailoverClient(&redis.FailoverOptions{
-			IdleTimeout:   redisIdleTimeout,
-			Password:      options.Password,
-			DB:            options.Database,
-			PoolSize:      options.PoolSize,
-			SentinelAddrs: strings.Split(options.SentinelAddrs, ","),
-			MasterName:    options.RedisMasterName,
-		})
-	} else {
-		return errors.New("Configure requires either the Server or Sentinels option")
+	rc, err := newRedisClient(options)
+	if err != nil {
+		return err
 	}
 
+	_, cluster := rc.(*redis.ClusterClient)
+
 	Config = &config{
 		processId:    options.ProcessID,
 		Namespace:    options.Namespace,
 		PollInterval: options.PollInterval,
-		Client:       rc,
+		AtMostOnce:   options.AtMostOnce,
+
+		AdaptivePollInterval:    options.AdaptivePollInterval,
+		SchedulerLeaderElection: options.SchedulerLeaderElection,
+
+		Client: rc,
 		Fetch: func(queue string) Fetcher {
 			return NewFetch(queue, make(chan *Msg), make(chan bool))
 		},
+		cluster: cluster,
 	}
+	cancelled.reset()
 	return nil
 }
+
+func newRedisClient(options Options) (redis.UniversalClient, error) {
+	if options.Client != nil {
+		return options.Client, nil
+	}
+
+	if options.URL != "" && options.RedisOptions == nil {
+		parsed, err := ParseURL(options.URL)
+		if err != nil {
+			return nil, err
+		}
+		options.RedisOptions = parsed.RedisOptions
+		if options.Username == "" {
+			options.Username = parsed.Username
+		}
+	}
+
+	redisIdleTimeout := 240 * time.Second
+
+	switch {
+	case options.ClusterOptions != nil:
+		opts := *options.ClusterOptions
+		if options.Username != "" {
+			opts.OnConnect = authenticate(options.Username, opts.Password, 0, opts.OnConnect)
+			opts.Password = ""
+		}
+		return redis.NewClusterClient(&opts), nil
+	case options.FailoverOptions != nil:
+		opts := *options.FailoverOptions
+		if options.Username != "" {
+			opts.OnConnect = authenticate(options.Username, opts.Password, opts.DB, opts.OnConnect)
+			opts.Password, opts.DB = "", 0
+		}
+		return redis.NewFailoverClient(&opts), nil
+	case options.RedisOptions != nil:
+		opts := *options.RedisOptions
+		if options.Username != "" {
+			opts.OnConnect = authenticate(options.Username, opts.Password, opts.DB, opts.OnConnect)
+			opts.Password, opts.DB = "", 0
+		}
+		return redis.NewClient(&opts), nil
+	case options.ServerAddr != "":
+		return newRedisClient(Options{
+			Username: options.Username,
+			RedisOptions: &redis.Options{
+				IdleTimeout: redisIdleTimeout,
+				Password:    options.Password,
+				DB:          options.Database,
+				PoolSize:    options.PoolSize,
+				Addr:        options.ServerAddr,
+			},
+		})
+	case options.SentinelAddrs != "":
+		if options.RedisMasterName == "" {
+			return nil, errors.New("Sentinel configuration requires a master name")
+		}
+
+		return newRedisClient(Options{
+			Username: options.Username,
+			FailoverOptions: &redis.FailoverOptions{
+				IdleTimeout:   redisIdleTimeout,
+				Password:      options.Password,
+				DB:            options.Database,
+				PoolSize:      options.PoolSize,
+				SentinelAddrs: strings.Split(options.SentinelAddrs, ","),
+				MasterName:    options.RedisMasterName,
+			},
+		})
+	case options.ClusterAddrs != "":
+		if options.Database != 0 {
+			return nil, errors.New("Cluster configuration doesn't support selecting a database")
+		}
+
+		return newRedisClient(Options{
+			Username: options.Username,
+			ClusterOptions: &redis.ClusterOptions{
+				IdleTimeout: redisIdleTimeout,
+				Password:    options.Password,
+				PoolSize:    options.PoolSize,
+				Addrs:       strings.Split(options.ClusterAddrs, ","),
+			},
+		})
+	}
+
+	return nil, errors.New("Configure requires either the Server, Sentinels or Cluster option")
+}
+
+// authenticate returns an OnConnect hook that logs in as an ACL user, which
+// go-redis can't do by itself, then selects the database.
+func authenticate(username, password string, db int, next func(*redis.Conn) error) func(*redis.Conn) error {
+	return func(conn *redis.Conn) error {
+		if err := conn.Do("AUTH", username, password).Err(); err != nil {
+			return err
+		}
+		if db > 0 {
+			if err := conn.Select(db).Err(); err != nil {
+				return err
+			}
+		}
+		if next != nil {
+			return next(conn)
+		}
+		return nil
+	}
+}
+
+// ParseURL parses a redis:// or rediss:// URL of the form
+//
+//	redis://[[username]:password@]host[:port][/database][?option=value]
+//
+// into Options, which can be passed to Configure once a ProcessID is set.
+// Supported options are dial_timeout, read_timeout and write_timeout (as
+// durations, e.g. "5s") and pool_size. rediss:// URLs connect using TLS.
+func ParseURL(redisURL string) (Options, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return Options{}, err
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return Options{}, errors.New("invalid redis URL scheme: " + u.Scheme)
+	}
+
+	opts := &redis.Options{Network: "tcp"}
+	options := Options{URL: redisURL, RedisOptions: opts}
+
+	if u.User != nil {
+		options.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			opts.Password = password
+		}
+	}
+
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "6379"
+	}
+	opts.Addr = net.JoinHostPort(host, port)
+
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if opts.DB, err = strconv.Atoi(path); err != nil {
+			return Options{}, fmt.Errorf("invalid redis database number: %q", path)
+		}
+	}
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "dial_timeout":
+			opts.DialTimeout, err = time.ParseDuration(value)
+		case "read_timeout":
+			opts.ReadTimeout, err = time.ParseDuration(value)
+		case "write_timeout":
+			opts.WriteTimeout, err = time.ParseDuration(value)
+		case "pool_size":
+			opts.PoolSize, err = strconv.Atoi(value)
+		default:
+			err = errors.New("unsupported redis URL option: " + key)
+		}
+		if err != nil {
+			return Options{}, err
+		}
+	}
+
+	if u.Scheme == "rediss" {
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+
+	return options, nil
+}
+
+// queueKey returns the redis key of a queue's list. In cluster mode the
+// queue name is wrapped in a hash tag, so the list and every key derived
+// from it (such as in-progress lists) land on the same slot, as
+// BRPOPLPUSH requires.
+func (c *config) queueKey(queue string) string {
+	if c.cluster {
+		return c.Namespace + "queue:{" + queue + "}"
+	}
+	return c.Namespace + "queue:" + queue
+}
+
+// queueName is the inverse of queueKey.
+func (c *config) queueName(key string) string {
+	queue := strings.TrimPrefix(key, c.Namespace+"queue:")
+	if c.cluster {
+		queue = strings.TrimSuffix(strings.TrimPrefix(queue, "{"), "}")
+	}
+	return queue
+}
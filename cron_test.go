@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cronNext(t *testing.T, spec string, location *time.Location, from string) string {
+	schedule, err := parseCron(spec, location)
+	assert.NoError(t, err)
+
+	start, _ := time.ParseInLocation("2006-01-02 15:04", from, location)
+	next, err := schedule.next(start)
+	assert.NoError(t, err)
+
+	return next.Format("2006-01-02 15:04 Mon")
+}
+
+func TestCronNext(t *testing.T) {
+	utc := time.UTC
+
+	assert.Equal(t, "2018-10-26 12:01 Fri", cronNext(t, "* * * * *", utc, "2018-10-26 12:00"))
+	assert.Equal(t, "2018-10-26 12:15 Fri", cronNext(t, "*/15 * * * *", utc, "2018-10-26 12:00"))
+	assert.Equal(t, "2018-10-26 13:05 Fri", cronNext(t, "5 * * * *", utc, "2018-10-26 12:05"))
+	assert.Equal(t, "2018-10-27 00:00 Sat", cronNext(t, "@daily", utc, "2018-10-26 12:00"))
+	assert.Equal(t, "2018-10-29 09:30 Mon", cronNext(t, "30 9 * * mon-fri", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2018-10-28 00:00 Sun", cronNext(t, "0 0 * * 7", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2019-02-01 00:00 Fri", cronNext(t, "0 0 1 feb *", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2019-01-01 00:00 Tue", cronNext(t, "@yearly", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2020-02-29 00:00 Sat", cronNext(t, "0 0 29 2 *", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2018-10-26 12:20 Fri", cronNext(t, "5,20,40 12 * * *", utc, "2018-10-26 12:05"))
+
+	//matches either day field when both are restricted
+	assert.Equal(t, "2018-10-29 00:00 Mon", cronNext(t, "0 0 1 * mon", utc, "2018-10-26 10:00"))
+	assert.Equal(t, "2018-11-01 00:00 Thu", cronNext(t, "0 0 1 * mon", utc, "2018-10-29 10:00"))
+
+	//evaluates in the given time zone
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	assert.Equal(t, "2018-10-27 09:00 Sat", cronNext(t, "0 9 * * *", newYork, "2018-10-26 10:00"))
+
+	schedule, _ := parseCron("0 9 * * *", newYork)
+	next, _ := schedule.next(time.Date(2018, 10, 26, 14, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2018, 10, 27, 13, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestCronParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(spec, time.UTC)
+		assert.Error(t, err, spec)
+	}
+}
@@ -1,10 +1,12 @@
 package workers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -21,12 +23,50 @@ type EnqueueData struct {
 	Jid        string      `json:"jid"`
 	EnqueuedAt float64     `json:"enqueued_at"`
 	EnqueueOptions
+
+	ctx context.Context
+}
+
+// Context is the context the job was enqueued with by EnqueueContext, so
+// client middlewares can read it.
+func (d *EnqueueData) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
 }
 
 type EnqueueOptions struct {
 	RetryCount int     `json:"retry_count,omitempty"`
 	Retry      bool    `json:"retry,omitempty"`
 	At         float64 `json:"at,omitempty"`
+
+	// TrackStatus records the job's state as it runs, see Status.
+	TrackStatus bool `json:"track_status,omitempty"`
+
+	// StoreResult keeps the job's outcome and the value it returns, see
+	// WithResult and Await.
+	StoreResult bool `json:"store_result,omitempty"`
+
+	// Batch is the ID of the batch the job belongs to, set by
+	// Batch.Enqueue.
+	Batch string `json:"bid,omitempty"`
+
+	// ExpiresAt discards the job if it hasn't started by then, in seconds
+	// like At. ExpiresIn does the same in seconds after enqueued_at, which
+	// for a job scheduled with At is when it's due and moved onto its
+	// queue. ExpiresIn is turned into ExpiresAt each time a job is
+	// enqueued, so periodic jobs and workflow steps keep it.
+	ExpiresAt float64 `json:"expires_at,omitempty"`
+	ExpiresIn float64 `json:"expires_in,omitempty"`
+
+	// Metadata is carried with the job, see Msg.Metadata.
+	Metadata Metadata `json:"metadata,omitempty"`
+
+	// Workflow and WorkflowStep identify the workflow step the job runs,
+	// set by Workflow.Start.
+	Workflow     string `json:"wfid,omitempty"`
+	WorkflowStep string `json:"wfstep,omitempty"`
 }
 
 func generateJid() string {
@@ -52,39 +92,142 @@ func EnqueueAt(queue, class string, at time.Time, args interface{}) (string, err
 }
 
 func EnqueueWithOptions(queue, class string, args interface{}, opts EnqueueOptions) (string, error) {
-	now := nowToSecondsWithNanoPrecision()
-	data := EnqueueData{
+	return EnqueueContext(context.Background(), queue, class, args, opts)
+}
+
+// EnqueueContext enqueues a job with the metadata ctx carries, see
+// WithMetadata, along with opts.Metadata. Client middlewares can read ctx
+// from EnqueueData.Context.
+func EnqueueContext(ctx context.Context, queue, class string, args interface{}, opts EnqueueOptions) (string, error) {
+	if md := MetadataFromContext(ctx); len(md) > 0 {
+		merged := Metadata{}
+		for key, value := range md {
+			merged[key] = value
+		}
+		for key, value := range opts.Metadata {
+			merged[key] = value
+		}
+		opts.Metadata = merged
+	}
+
+	data := newEnqueueData(queue, class, args, opts)
+	data.ctx = ctx
+
+	if pushed, err := enqueue(data); err != nil || !pushed {
+		return "", err
+	}
+
+	return data.Jid, nil
+}
+
+// EnqueueBulk enqueues a job for each of args in one round trip, returning
+// their jids in the same order. Jobs dropped by a client middleware have
+// an empty jid, and none are enqueued if one is rejected.
+func EnqueueBulk(queue, class string, args []interface{}, opts EnqueueOptions) ([]string, error) {
+	jids := make([]string, len(args))
+	if len(args) == 0 {
+		return jids, nil
+	}
+
+	// A transaction, so a rejected job or a failed write leaves nothing
+	// behind, including the statuses of tracked jobs
+	pipe := Config.Client.TxPipeline()
+
+	for i, arg := range args {
+		data := newEnqueueData(queue, class, arg, opts)
+
+		pushed, err := runClientMiddlewares(data, func(data *EnqueueData) error {
+			bytes, err := encodeJob(data)
+			if err != nil {
+				return err
+			}
+			queuePayload(pipe, *data, bytes)
+			return nil
+		})
+		if err != nil {
+			pipe.Close()
+			return nil, err
+		}
+		if pushed {
+			jids[i] = data.Jid
+		}
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+
+	return jids, nil
+}
+
+// newEnqueueData builds a job with its own copy of opts.Metadata, so
+// client middlewares can change it without touching the caller's map or
+// the other jobs of a bulk enqueue.
+func newEnqueueData(queue, class string, args interface{}, opts EnqueueOptions) *EnqueueData {
+	if opts.Metadata != nil {
+		metadata := make(Metadata, len(opts.Metadata))
+		for key, value := range opts.Metadata {
+			metadata[key] = value
+		}
+		opts.Metadata = metadata
+	}
+
+	return &EnqueueData{
 		Queue:          queue,
 		Class:          class,
 		Args:           args,
 		Jid:            generateJid(),
-		EnqueuedAt:     now,
+		EnqueuedAt:     nowToSecondsWithNanoPrecision(),
 		EnqueueOptions: opts,
 	}
+}
 
-	bytes, err := json.Marshal(data)
-	if err != nil {
-		return "", err
-	}
+// enqueue runs a job through the client middlewares, then pushes it onto
+// its queue, or the scheduled set if it's due in the future. Returns false
+// if a middleware dropped it.
+func enqueue(data *EnqueueData) (bool, error) {
+	return runClientMiddlewares(data, func(data *EnqueueData) error {
+		bytes, err := encodeJob(data)
+		if err != nil {
+			return err
+		}
+		return enqueuePayload(*data, bytes)
+	})
+}
 
-	if now < opts.At {
-		err := enqueueAt(data.At, bytes)
-		return data.Jid, err
+// encodeJob resolves the job's relative options and encodes its payload.
+func encodeJob(data *EnqueueData) ([]byte, error) {
+	if data.ExpiresIn > 0 && data.ExpiresAt == 0 {
+		data.ExpiresAt = math.Max(data.EnqueuedAt, data.At) + data.ExpiresIn
 	}
+	data.ExpiresIn = 0
 
-	rc := Config.Client
+	return json.Marshal(data)
+}
 
-	_, err = rc.SAdd(Config.Namespace+"queues", queue).Result()
-	if err != nil {
-		return "", err
+// enqueuePayload pushes a job that's already encoded, along with its
+// status if it's tracked.
+func enqueuePayload(data EnqueueData, bytes []byte) error {
+	pipe := Config.Client.TxPipeline()
+	queuePayload(pipe, data, bytes)
+	_, err := pipe.Exec()
+	return err
+}
+
+// queuePayload queues the writes that enqueue a job that's already encoded
+// on pipe.
+func queuePayload(pipe redis.Pipeliner, data EnqueueData, bytes []byte) {
+	if data.TrackStatus {
+		enqueuedStatus(pipe, data, data.EnqueuedAt < data.At)
 	}
-	queue = Config.Namespace + "queue:" + queue
-	_, err = rc.LPush(queue, bytes).Result()
-	if err != nil {
-		return "", err
+
+	if data.EnqueuedAt < data.At {
+		pipe.ZAdd(Config.Namespace+SCHEDULED_JOBS_KEY, redis.Z{Score: data.At, Member: bytes})
+		return
 	}
 
-	return data.Jid, nil
+	pipe.SAdd(Config.Namespace+"queues", data.Queue)
+	pipe.LPush(Config.queueKey(data.Queue), bytes)
 }
 
 func enqueueAt(at float64, bytes []byte) error {
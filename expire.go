@@ -0,0 +1,20 @@
+package workers
+
+import "errors"
+
+// ErrExpired is recorded for jobs discarded because they expired before
+// they could run, see EnqueueOptions.ExpiresAt.
+var ErrExpired = errors.New("job expired")
+
+// expired reports whether the job had to start by a time that has passed.
+func (m *Msg) expired() bool {
+	expiresAt, err := m.Get("expires_at").Float64()
+	return err == nil && expiresAt > 0 && expiresAt < nowToSecondsWithNanoPrecision()
+}
+
+// discardExpired records a job that expired instead of running it.
+func discardExpired(queue string, message *Msg) {
+	incrementStats("expired")
+	finishSkipped(message, StatusExpired, ErrExpired)
+	runExpiredHandlers(queue, message)
+}
@@ -0,0 +1,136 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiresIn(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	//expires relative to when the job is enqueued
+	EnqueueWithOptions("expireQueue1", "Add", nil, EnqueueOptions{ExpiresIn: 60})
+
+	payload, _ := rc.LIndex("prod:queue:expireQueue1", 0).Result()
+	message, _ := NewMsg(payload)
+	enqueuedAt, _ := message.Get("enqueued_at").Float64()
+	expiresAt, _ := message.Get("expires_at").Float64()
+	assert.InDelta(t, enqueuedAt+60, expiresAt, 0.001)
+
+	//or when it's due, for scheduled jobs
+	at := nowToSecondsWithNanoPrecision() + 3600
+	EnqueueWithOptions("expireQueue1", "Add", nil, EnqueueOptions{At: at, ExpiresIn: 60})
+
+	scheduled, _ := ScheduledSet().Page(0, -1)
+	expiresAt, _ = scheduled[0].Get("expires_at").Float64()
+	assert.InDelta(t, at+60, expiresAt, 0.001)
+}
+
+func TestExpiresInIsKeptByPeriodicJobsAndWorkflows(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	expiresIn := func(queue string) float64 {
+		payload, _ := rc.LPop("prod:queue:" + queue).Result()
+		message, _ := NewMsg(payload)
+		enqueuedAt, _ := message.Get("enqueued_at").Float64()
+		expiresAt, _ := message.Get("expires_at").Float64()
+		assert.Nil(t, message.Get("expires_in").Interface())
+		return expiresAt - enqueuedAt
+	}
+
+	//expires each run of a periodic job
+	RegisterPeriodicJob(PeriodicJob{
+		Name:    "warm",
+		Spec:    "* * * * *",
+		Queue:   "expireQueue1",
+		Class:   "Warm",
+		Options: EnqueueOptions{ExpiresIn: 60},
+	})
+	rc.HSet("prod:"+PERIODIC_LAST_RUN_KEY, "warm", time.Now().Add(-5*time.Minute).Unix()).Result()
+	assert.NoError(t, newPeriodic().poll())
+	assert.InDelta(t, 60, expiresIn("expireQueue1"), 0.001)
+
+	//and workflow steps
+	workflow := NewWorkflow("notify")
+	workflow.Add("fetch", "expireQueue1", "Fetch", nil)
+	workflow.Add("send", "expireQueue2", "Send", nil, "fetch").Options = EnqueueOptions{ExpiresIn: 30}
+	assert.NoError(t, workflow.Start())
+
+	payload, _ := rc.RPop("prod:queue:expireQueue1").Result()
+	message, _ := NewMsg(payload)
+	WorkflowMiddleware("prod:expireQueue1", func(message *Msg) error {
+		return nil
+	})(message)
+	assert.InDelta(t, 30, expiresIn("expireQueue2"), 0.001)
+}
+
+func TestExpiredJobsAreSkippedAtFetch(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	var expired []string
+	OnExpired(func(queue string, message *Msg) {
+		expired = append(expired, queue+":"+message.Jid())
+	})
+	defer func() {
+		expiredHandlers = nil
+	}()
+
+	now := nowToSecondsWithNanoPrecision()
+	jid, _ := EnqueueWithOptions("expireQueue2", "Add", nil, EnqueueOptions{ExpiresAt: now - 1, TrackStatus: true})
+	fresh, _ := EnqueueWithOptions("expireQueue2", "Add", nil, EnqueueOptions{ExpiresAt: now + 60})
+
+	fetch := buildFetch("expireQueue2")
+
+	fetch.Ready() <- true
+	fetch.Ready() <- true
+	message := <-fetch.Messages()
+	assert.Equal(t, fresh, message.Jid())
+
+	//discards expired jobs without running them
+	inprogress, _ := rc.LRange("prod:queue:expireQueue2:1:inprogress", 0, -1).Result()
+	assert.Equal(t, []string{message.OriginalJson()}, inprogress)
+
+	count, _ := rc.Get("prod:stat:expired").Result()
+	assert.Equal(t, "1", count)
+
+	status, _ := Status(jid)
+	assert.Equal(t, StatusExpired, status.Status)
+
+	assert.Equal(t, []string{"expireQueue2:" + jid}, expired)
+
+	fetch.Close()
+}
+
+func TestExpiredJobsAreSkippedAtPromotion(t *testing.T) {
+	for _, cluster := range []bool{false, true} {
+		setupTestConfigWithNamespace("prod")
+		Config.cluster = cluster
+		rc := Config.Client
+
+		now := nowToSecondsWithNanoPrecision()
+
+		rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 60, Member: "{\"queue\":\"default\",\"jid\":\"1\",\"expires_at\":1}"})
+		rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 60, Member: fmt.Sprintf("{\"queue\":\"default\",\"jid\":\"2\",\"expires_at\":%f}", now+60)})
+
+		assert.NoError(t, newScheduled(RETRY_KEY).poll())
+
+		//only promotes jobs that haven't expired
+		queued, _ := rc.LRange(Config.queueKey("default"), 0, -1).Result()
+		assert.Equal(t, 1, len(queued))
+		message, _ := NewMsg(queued[0])
+		assert.Equal(t, "2", message.Jid())
+
+		pending, _ := rc.ZCard("prod:" + RETRY_KEY).Result()
+		assert.Equal(t, int64(0), pending)
+
+		count, _ := rc.Get("prod:stat:expired").Result()
+		assert.Equal(t, "1", count)
+	}
+}
@@ -2,6 +2,7 @@ package workers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -138,3 +139,77 @@ func TestRetryInprogressMessages(t *testing.T) {
 
 	fetch.Close()
 }
+
+func TestQuarantineUndecodableMessages(t *testing.T) {
+	setupTestConfig()
+	message, _ := NewMsg("{\"foo\":\"bar\"}")
+
+	rc := Config.Client
+
+	rc.LPush("queue:fetchQueue7:1:inprogress", "{\"foo\":").Result()
+	rc.LPush("queue:fetchQueue7", message.ToJson()).Result()
+
+	fetch := buildFetch("fetchQueue7")
+
+	fetch.Ready() <- true
+	fetch.Ready() <- true
+	assert.Equal(t, message, <-fetch.Messages())
+
+	len, _ := rc.LLen("queue:fetchQueue7:1:inprogress").Result()
+	assert.Equal(t, int64(1), len)
+
+	quarantined, _ := rc.ZCard(QUARANTINE_KEY).Result()
+	assert.Equal(t, int64(1), quarantined)
+
+	fetch.Close()
+}
+
+func TestAcknowledgeRetriesThenFails(t *testing.T) {
+	oldBackoff := ackBackoff
+	defer func() {
+		ackBackoff = oldBackoff
+	}()
+	ackBackoff = time.Millisecond
+
+	Configure(Options{
+		ServerAddr: "localhost:1",
+		ProcessID:  "1",
+	})
+	defer setupTestConfig()
+
+	message, _ := NewMsg("{\"foo\":\"bar\"}")
+	fetch := NewFetch("queue:fetchQueue8", make(chan *Msg), make(chan bool))
+
+	assert.Error(t, fetch.Acknowledge(message))
+}
+
+func TestDoesntFetchFromPausedQueues(t *testing.T) {
+	setupTestConfig()
+	oldInterval := pausedCheckInterval
+	defer func() {
+		pausedCheckInterval = oldInterval
+	}()
+	pausedCheckInterval = 10 * time.Millisecond
+
+	message, _ := NewMsg("{\"foo\":\"bar\"}")
+
+	rc := Config.Client
+
+	NewQueue("fetchQueue9").Pause()
+	rc.LPush("queue:fetchQueue9", message.ToJson()).Result()
+
+	fetch := buildFetch("fetchQueue9")
+	fetch.Ready() <- true
+
+	select {
+	case <-fetch.Messages():
+		t.Error("fetched a message from a paused queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	NewQueue("fetchQueue9").Resume()
+	fetch.Ready() <- true
+	assert.Equal(t, message, <-fetch.Messages())
+
+	fetch.Close()
+}
@@ -7,10 +7,15 @@ import (
 	"github.com/go-redis/redis"
 )
 
+// These are variables for testing reasons
+var ackAttempts = 5
+var ackBackoff = 50 * time.Millisecond
+var pausedCheckInterval = 1 * time.Second
+
 type Fetcher interface {
 	Queue() string
 	Fetch()
-	Acknowledge(*Msg)
+	Acknowledge(*Msg) error
 	Ready() chan bool
 	FinishedWork() chan bool
 	Messages() chan *Msg
@@ -20,23 +25,32 @@ type Fetcher interface {
 
 type fetch struct {
 	queue        string
+	backoff      *backoff
+	errors       *logLimiter
 	ready        chan bool
 	finishedwork chan bool
 	messages     chan *Msg
 	stop         chan bool
 	exit         chan bool
 	closed       chan bool
+
+	paused          bool
+	pausedCheckedAt time.Time
 }
 
 func NewFetch(queue string, messages chan *Msg, ready chan bool) Fetcher {
 	return &fetch{
 		queue,
+		newBackoff(),
+		newLogLimiter(),
 		ready,
 		make(chan bool),
 		messages,
 		make(chan bool),
 		make(chan bool),
 		make(chan bool),
+		false,
+		time.Time{},
 	}
 }
 
@@ -82,34 +96,100 @@ func (f *fetch) Fetch() {
 func (f *fetch) tryFetchMessage() {
 	rc := Config.Client
 
+	if f.isPaused() {
+		time.Sleep(pausedCheckInterval)
+		return
+	}
+
 	message, err := rc.BRPopLPush(f.queue, f.inprogressQueue(), 1*time.Second).Result()
 
-	if err != nil {
-		// If redis returns null, the queue is empty. Just ignore the error.
-		if err == redis.Nil {
-			Logger.Println("ERR: ", err)
-			time.Sleep(1 * time.Second)
-		}
-	} else {
+	if err != nil && err != redis.Nil {
+		broker.failed(err)
+		f.errors.Println("ERR: couldn't fetch from", f.queue, ":", err)
+		time.Sleep(f.backoff.next())
+		return
+	}
+
+	broker.succeeded()
+	f.backoff.reset()
+
+	// If redis returns null, the queue is empty. Just ignore the error.
+	if err == nil {
 		f.sendMessage(message)
 	}
 }
 
+// isPaused reports whether the queue has been paused, checking with redis
+// at most once every pausedCheckInterval.
+func (f *fetch) isPaused() bool {
+	if time.Since(f.pausedCheckedAt) < pausedCheckInterval {
+		return f.paused
+	}
+
+	rc := Config.Client
+
+	paused, err := rc.SIsMember(Config.Namespace+PAUSED_QUEUES_KEY, Config.queueName(f.queue)).Result()
+	if err == nil {
+		f.paused = paused
+		f.pausedCheckedAt = time.Now()
+	}
+
+	return f.paused
+}
+
 func (f *fetch) sendMessage(message string) {
 	msg, err := NewMsg(message)
 
 	if err != nil {
 		Logger.Println("ERR: Couldn't create message from", message, ":", err)
+
+		if err := quarantine(f.queue, f.inprogressQueue(), message, err); err != nil {
+			Logger.Println("ERR: Couldn't quarantine message", message, ":", err)
+		}
+		return
+	}
+
+	if cancelled.has(msg.Jid()) {
+		Logger.Println("skipping cancelled job", msg.Jid(), "from", f.queue)
+
+		if err := f.Acknowledge(msg); err != nil {
+			Logger.Println("ERR: Couldn't acknowledge cancelled job", msg.Jid(), ":", err)
+		}
+		incrementStats("cancelled")
+		finishSkipped(msg, StatusCancelled, ErrCancelled)
+		return
+	}
+
+	if msg.expired() {
+		Logger.Println("skipping expired job", msg.Jid(), "from", f.queue)
+
+		if err := f.Acknowledge(msg); err != nil {
+			Logger.Println("ERR: Couldn't acknowledge expired job", msg.Jid(), ":", err)
+		}
+		discardExpired(Config.queueName(f.queue), msg)
 		return
 	}
 
 	f.Messages() <- msg
 }
 
-func (f *fetch) Acknowledge(message *Msg) {
+// Acknowledge removes a message from the in-progress list, retrying with
+// exponential backoff so a transient redis error doesn't leave a finished
+// job behind to be executed again on restart.
+func (f *fetch) Acknowledge(message *Msg) (err error) {
 	rc := Config.Client
 
-	rc.LRem(f.inprogressQueue(), -1, message.OriginalJson()).Result()
+	for attempt := 0; attempt < ackAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ackBackoff << uint(attempt-1))
+		}
+
+		if _, err = rc.LRem(f.inprogressQueue(), -1, message.OriginalJson()).Result(); err == nil {
+			return nil
+		}
+	}
+
+	return err
 }
 
 func (f *fetch) Messages() chan *Msg {
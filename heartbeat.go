@@ -0,0 +1,375 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// These are variables for testing reasons
+var heartbeatInterval = 5 * time.Second
+var heartbeatTTL = 60 * time.Second
+
+// heartbeat periodically records this process in the Sidekiq-compatible
+// "processes" set, so the number of live processes is known cluster-wide.
+type heartbeat struct {
+	identity  string
+	startedAt float64
+	closed    chan bool
+}
+
+type ProcessInfo struct {
+	Hostname    string   `json:"hostname"`
+	StartedAt   float64  `json:"started_at"`
+	Pid         int      `json:"pid"`
+	Tag         string   `json:"tag"`
+	Concurrency int      `json:"concurrency"`
+	Queues      []string `json:"queues"`
+	Labels      []string `json:"labels"`
+	Identity    string   `json:"identity"`
+}
+
+func (h *heartbeat) start() {
+	go (func() {
+		for {
+			if err := h.beat(); err != nil {
+				Logger.Println("ERR: couldn't send heartbeat:", err)
+			}
+
+			select {
+			case <-h.closed:
+				return
+			case <-time.After(heartbeatInterval):
+			}
+		}
+	})()
+}
+
+func (h *heartbeat) quit() {
+	close(h.closed)
+
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	pipe.SRem(Config.Namespace+"processes", h.identity)
+	pipe.Del(Config.Namespace + h.identity)
+	pipe.Del(Config.Namespace + h.identity + ":workers")
+	if _, err := pipe.Exec(); err != nil {
+		Logger.Println("ERR: couldn't remove heartbeat:", err)
+	}
+}
+
+func (h *heartbeat) beat() error {
+	rc := Config.Client
+
+	hostname, _ := os.Hostname()
+	info := ProcessInfo{
+		Hostname:  hostname,
+		StartedAt: h.startedAt,
+		Pid:       os.Getpid(),
+		Queues:    []string{},
+		Labels:    []string{},
+		Identity:  h.identity,
+	}
+
+	busy := 0
+	work := make(map[string]interface{})
+	for _, m := range currentManagers() {
+		info.Concurrency += m.size()
+		info.Queues = append(info.Queues, Config.queueName(m.queue))
+		busy += m.processing()
+
+		for id, running := range m.running() {
+			bytes, err := json.Marshal(running)
+			if err != nil {
+				return err
+			}
+			work[id] = string(bytes)
+		}
+	}
+
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	key := Config.Namespace + h.identity
+
+	pipe := rc.Pipeline()
+	pipe.SAdd(Config.Namespace+"processes", h.identity)
+	pipe.HMSet(key, map[string]interface{}{
+		"info":  string(bytes),
+		"busy":  busy,
+		"beat":  nowToSecondsWithNanoPrecision(),
+		"quiet": fmt.Sprint(isQuiet()),
+	})
+	pipe.Expire(key, heartbeatTTL)
+	pipe.Del(key + ":workers")
+	if len(work) > 0 {
+		pipe.HMSet(key+":workers", work)
+		pipe.Expire(key+":workers", heartbeatTTL)
+	}
+	signal := pipe.RPop(key + "-signals")
+	if _, err = pipe.Exec(); err != nil && err != redis.Nil {
+		return err
+	}
+
+	if signal.Err() == nil {
+		h.handleSignal(signal.Val())
+	}
+
+	return nil
+}
+
+// handleSignal acts on signals sent through redis, which use the same
+// names and list as Sidekiq so Sidekiq's web UI can control this process.
+// CONCURRENCY <workers> <queue> is specific to go-workers.
+func (h *heartbeat) handleSignal(signal string) {
+	Logger.Println("received", signal, "signal through redis")
+
+	fields := strings.Fields(signal)
+	switch {
+	case signal == "TSTP":
+		Quiet()
+	case signal == "TERM":
+		go Quit()
+	case signal == "TTIN":
+		logRunningJobs()
+	case len(fields) == 3 && fields[0] == "CONCURRENCY":
+		concurrency, err := strconv.Atoi(fields[1])
+		if err != nil {
+			Logger.Println("ignoring invalid signal", signal)
+			return
+		}
+
+		// Shrinking waits for busy workers to finish their job
+		go func() {
+			if err := setConcurrency(fields[2], concurrency); err != nil {
+				Logger.Println("ERR: couldn't change concurrency:", err)
+			}
+		}()
+	default:
+		Logger.Println("ignoring unknown signal", signal)
+	}
+}
+
+// runningWork is a job being processed, stored in the same format Sidekiq
+// uses so Sidekiq's web UI can show it.
+type runningWork struct {
+	queue   string
+	message *Msg
+	at      time.Time
+}
+
+func (w *runningWork) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"queue":   w.queue,
+		"payload": json.RawMessage(w.message.OriginalJson()),
+		"run_at":  w.at.Unix(),
+	})
+}
+
+// logRunningJobs logs every job this process is running.
+func logRunningJobs() {
+	count := 0
+	for _, m := range currentManagers() {
+		for id, running := range m.running() {
+			class, _ := running.message.Get("class").String()
+			Logger.Println("worker", id, "running", class, running.message.Jid(), "for", time.Since(running.at))
+			count++
+		}
+	}
+
+	Logger.Println(count, "jobs running")
+}
+
+// RunningJob is a job being processed by a live process.
+type RunningJob struct {
+	*Msg
+	Process string
+	Queue   string
+	RunAt   time.Time
+}
+
+// RunningJobs returns the jobs being processed by every live process, as of
+// their last heartbeat.
+func RunningJobs() ([]*RunningJob, error) {
+	processes, err := Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := Config.Client
+
+	jobs := []*RunningJob{}
+	for _, process := range processes {
+		work, err := rc.HGetAll(Config.Namespace + process.Identity + ":workers").Result()
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(work))
+		for id := range work {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			var running struct {
+				Queue   string          `json:"queue"`
+				Payload json.RawMessage `json:"payload"`
+				RunAt   int64           `json:"run_at"`
+			}
+			if err := json.Unmarshal([]byte(work[id]), &running); err != nil {
+				continue
+			}
+
+			message, err := NewMsg(string(running.Payload))
+			if err != nil {
+				continue
+			}
+
+			jobs = append(jobs, &RunningJob{message, process.Identity, running.Queue, time.Unix(running.RunAt, 0)})
+		}
+	}
+
+	return jobs, nil
+}
+
+// RunningProcess is a process with a live heartbeat.
+type RunningProcess struct {
+	ProcessInfo
+	Busy  int
+	Beat  time.Time
+	Quiet bool
+}
+
+// Processes returns every process with a live heartbeat, sorted by
+// identity, pruning processes that died without cleaning up after
+// themselves.
+func Processes() ([]*RunningProcess, error) {
+	rc := Config.Client
+
+	identities, err := rc.SMembers(Config.Namespace + "processes").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(identities)
+
+	processes := []*RunningProcess{}
+	for _, identity := range identities {
+		fields, err := rc.HGetAll(Config.Namespace + identity).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) == 0 {
+			rc.SRem(Config.Namespace+"processes", identity)
+			continue
+		}
+
+		process := &RunningProcess{}
+		json.Unmarshal([]byte(fields["info"]), &process.ProcessInfo)
+		process.Identity = identity
+		process.Busy, _ = strconv.Atoi(fields["busy"])
+		process.Quiet = fields["quiet"] == "true"
+		if beat, err := strconv.ParseFloat(fields["beat"], 64); err == nil {
+			process.Beat = secondsToTime(beat)
+		}
+
+		processes = append(processes, process)
+	}
+
+	return processes, nil
+}
+
+// QuietProcess asks a process to stop fetching new jobs, the next time it
+// sends a heartbeat.
+func QuietProcess(identity string) error {
+	return signalProcess(identity, "TSTP")
+}
+
+// StopProcess asks a process to finish its running jobs and exit, the next
+// time it sends a heartbeat.
+func StopProcess(identity string) error {
+	return signalProcess(identity, "TERM")
+}
+
+// DumpProcess asks a process to log the jobs it's running, the next time it
+// sends a heartbeat.
+func DumpProcess(identity string) error {
+	return signalProcess(identity, "TTIN")
+}
+
+// SetProcessConcurrency asks a process to change how many workers process
+// a queue, the next time it sends a heartbeat. Workers that are stopped
+// finish their running job first.
+func SetProcessConcurrency(identity, queue string, concurrency int) error {
+	if concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+
+	return signalProcess(identity, fmt.Sprintf("CONCURRENCY %d %s", concurrency, queue))
+}
+
+func signalProcess(identity, signal string) error {
+	rc := Config.Client
+
+	key := Config.Namespace + identity + "-signals"
+
+	pipe := rc.Pipeline()
+	pipe.LPush(key, signal)
+	pipe.Expire(key, heartbeatTTL)
+	_, err := pipe.Exec()
+	return err
+}
+
+// processCount returns the number of processes with a live heartbeat,
+// pruning processes that died without cleaning up after themselves. It
+// runs on every poll, so it only checks heartbeats exist.
+func processCount() (int, error) {
+	rc := Config.Client
+
+	identities, err := rc.SMembers(Config.Namespace + "processes").Result()
+	if err != nil || len(identities) == 0 {
+		return 0, err
+	}
+
+	pipe := rc.Pipeline()
+	alive := make([]*redis.IntCmd, len(identities))
+	for i, identity := range identities {
+		alive[i] = pipe.Exists(Config.Namespace + identity)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+
+	var dead []interface{}
+	for i, identity := range identities {
+		if alive[i].Val() == 0 {
+			dead = append(dead, identity)
+		}
+	}
+	if len(dead) > 0 {
+		rc.SRem(Config.Namespace+"processes", dead...)
+	}
+
+	return len(identities) - len(dead), nil
+}
+
+func processIdentity() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprint(hostname, ":", Config.processId)
+}
+
+func newHeartbeat() *heartbeat {
+	return &heartbeat{processIdentity(), nowToSecondsWithNanoPrecision(), make(chan bool)}
+}
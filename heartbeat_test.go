@@ -0,0 +1,152 @@
+package workers
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeat(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	heartbeat := newHeartbeat()
+	assert.NoError(t, heartbeat.beat())
+
+	//registers the process
+	found, _ := rc.SIsMember("prod:processes", heartbeat.identity).Result()
+	assert.True(t, found)
+
+	fields, _ := rc.HGetAll("prod:" + heartbeat.identity).Result()
+	assert.Equal(t, "0", fields["busy"])
+
+	var info ProcessInfo
+	assert.NoError(t, json.Unmarshal([]byte(fields["info"]), &info))
+	assert.Equal(t, heartbeat.identity, info.Identity)
+
+	ttl, _ := rc.TTL("prod:" + heartbeat.identity).Result()
+	assert.True(t, ttl > 0)
+
+	//counts live processes and prunes dead ones
+	rc.SAdd("prod:processes", "deadhost:2").Result()
+
+	count, err := processCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	found, _ = rc.SIsMember("prod:processes", "deadhost:2").Result()
+	assert.False(t, found)
+
+	//unregisters on quit
+	heartbeat.quit()
+
+	count, _ = processCount()
+	assert.Equal(t, 0, count)
+}
+
+func TestProcesses(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	heartbeat := newHeartbeat()
+	assert.NoError(t, heartbeat.beat())
+	defer heartbeat.quit()
+
+	processes, err := Processes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(processes))
+	assert.Equal(t, heartbeat.identity, processes[0].Identity)
+	assert.Equal(t, os.Getpid(), processes[0].Pid)
+	assert.False(t, processes[0].Quiet)
+	assert.WithinDuration(t, time.Now(), processes[0].Beat, time.Second)
+
+	//queues signals for the process to pick up
+	assert.NoError(t, QuietProcess(heartbeat.identity))
+	assert.NoError(t, StopProcess(heartbeat.identity))
+
+	signals, _ := rc.LRange("prod:"+heartbeat.identity+"-signals", 0, -1).Result()
+	assert.Equal(t, []string{"TERM", "TSTP"}, signals)
+
+	//picks up one signal per beat, oldest first
+	assert.NoError(t, heartbeat.beat())
+
+	signals, _ = rc.LRange("prod:"+heartbeat.identity+"-signals", 0, -1).Result()
+	assert.Equal(t, []string{"TERM"}, signals)
+}
+
+func TestQuietSignal(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	Process("myqueue", func(message *Msg) error { return nil }, 1)
+	defer forgetManager("myqueue")
+
+	Start()
+	defer Quit()
+
+	beat.handleSignal("TSTP")
+
+	//stops fetching and reports being quiet
+	assert.True(t, isQuiet())
+	assert.True(t, managers["myqueue"].fetch.Closed())
+	assert.NoError(t, beat.beat())
+
+	processes, _ := Processes()
+	assert.Equal(t, 1, len(processes))
+	assert.True(t, processes[0].Quiet)
+}
+
+func TestRemoteControl(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	started := make(chan bool)
+	release := make(chan bool)
+	Process("myqueue", func(message *Msg) error {
+		started <- true
+		<-release
+		return nil
+	}, 1)
+	defer forgetManager("myqueue")
+
+	Start()
+	defer Quit()
+
+	//publishes running jobs with heartbeats
+	jid, _ := Enqueue("myqueue", "Add", []int{1, 2})
+	<-started
+	assert.NoError(t, beat.beat())
+
+	jobs, err := RunningJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, jid, jobs[0].Jid())
+	assert.Equal(t, "myqueue", jobs[0].Queue)
+	assert.Equal(t, processIdentity(), jobs[0].Process)
+
+	//changes concurrency
+	assert.Error(t, SetProcessConcurrency(processIdentity(), "myqueue", 0))
+	assert.NoError(t, SetProcessConcurrency(processIdentity(), "myqueue", 3))
+
+	signal, _ := rc.RPop("prod:" + processIdentity() + "-signals").Result()
+	assert.Equal(t, "CONCURRENCY 3 myqueue", signal)
+
+	beat.handleSignal(signal)
+	for i := 0; i < 100 && managers["myqueue"].size() != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 3, managers["myqueue"].size())
+
+	assert.Error(t, setConcurrency("missing", 2))
+
+	release <- true
+	for i := 0; i < 100 && managers["myqueue"].processing() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, beat.beat())
+
+	jobs, _ = RunningJobs()
+	assert.Equal(t, 0, len(jobs))
+}
@@ -1,7 +1,20 @@
 package workers
 
+import "sync"
+
+// ErrorHandlerFunc is called with errors that happen outside of a job,
+// such as failing to acknowledge a message once it has been processed.
+type ErrorHandlerFunc func(queue string, message *Msg, err error)
+
+// ExpiredHandlerFunc is called with jobs discarded because they expired
+// before they could run.
+type ExpiredHandlerFunc func(queue string, message *Msg)
+
 var beforeStart []func()
 var duringDrain []func()
+var errorHandlers []ErrorHandlerFunc
+var errorHandlersM sync.RWMutex
+var expiredHandlers []ExpiredHandlerFunc
 
 func BeforeStart(f func()) {
 	access.Lock()
@@ -24,3 +37,31 @@ func runHooks(hooks []func()) {
 		f()
 	}
 }
+
+func OnError(f ErrorHandlerFunc) {
+	errorHandlersM.Lock()
+	defer errorHandlersM.Unlock()
+	errorHandlers = append(errorHandlers, f)
+}
+
+func runErrorHandlers(queue string, message *Msg, err error) {
+	errorHandlersM.RLock()
+	defer errorHandlersM.RUnlock()
+	for _, f := range errorHandlers {
+		f(queue, message, err)
+	}
+}
+
+func OnExpired(f ExpiredHandlerFunc) {
+	errorHandlersM.Lock()
+	defer errorHandlersM.Unlock()
+	expiredHandlers = append(expiredHandlers, f)
+}
+
+func runExpiredHandlers(queue string, message *Msg) {
+	errorHandlersM.RLock()
+	defer errorHandlersM.RUnlock()
+	for _, f := range expiredHandlers {
+		f(queue, message)
+	}
+}
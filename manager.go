@@ -1,7 +1,7 @@
 package workers
 
 import (
-	"strings"
+	"fmt"
 	"sync"
 )
 
@@ -15,6 +15,7 @@ type manager struct {
 	confirm     chan *Msg
 	stop        chan bool
 	exit        chan bool
+	acks        *sync.WaitGroup
 	*sync.WaitGroup
 }
 
@@ -43,6 +44,8 @@ func (m *manager) quit() {
 	m.stop <- true
 	<-m.exit
 
+	// Acks still running use the fetcher that reset replaces
+	m.acks.Wait()
 	m.reset()
 
 	m.Done()
@@ -56,7 +59,14 @@ func (m *manager) manage() {
 	for {
 		select {
 		case message := <-m.confirm:
-			m.fetch.Acknowledge(message)
+			// Acknowledge off the loop, so one retrying with backoff
+			// doesn't hold up confirmations from the other workers.
+			// The manager doesn't quit until they've all finished.
+			m.acks.Add(1)
+			go func() {
+				defer m.acks.Done()
+				m.acknowledge(message)
+			}()
 		case <-m.stop:
 			m.exit <- true
 			break
@@ -64,6 +74,16 @@ func (m *manager) manage() {
 	}
 }
 
+func (m *manager) acknowledge(message *Msg) bool {
+	if err := m.fetch.Acknowledge(message); err != nil {
+		Logger.Println("ERR: Couldn't acknowledge", message.Jid(), "on queue", m.queueName(), ":", err)
+		incrementStats("ack_failed")
+		runErrorHandlers(m.queueName(), message, err)
+		return false
+	}
+	return true
+}
+
 func (m *manager) loadWorkers() {
 	m.workersM.Lock()
 	for i := 0; i < m.concurrency; i++ {
@@ -73,10 +93,68 @@ func (m *manager) loadWorkers() {
 	m.workersM.Unlock()
 }
 
+// setConcurrency starts or stops workers until there are concurrency of
+// them. Stopped workers finish the job they're running first.
+func (m *manager) setConcurrency(concurrency int) {
+	m.workersM.Lock()
+
+	// Don't start workers on a manager that's quitting, as they'd never
+	// be stopped.
+	if m.fetch.Closed() && concurrency > len(m.workers) {
+		concurrency = len(m.workers)
+	}
+
+	var stopping []*worker
+	for len(m.workers) < concurrency {
+		worker := newWorker(m)
+		worker.start()
+		m.workers = append(m.workers, worker)
+	}
+	if len(m.workers) > concurrency {
+		stopping = m.workers[concurrency:]
+		m.workers = m.workers[:concurrency:concurrency]
+	}
+	m.concurrency = concurrency
+
+	m.workersM.Unlock()
+
+	// Wait for busy workers outside the lock, so heartbeats can still
+	// count the remaining ones.
+	for _, worker := range stopping {
+		worker.quit()
+	}
+}
+
+// running returns the jobs currently being processed, keyed by worker.
+func (m *manager) running() map[string]*runningWork {
+	m.workersM.Lock()
+	defer m.workersM.Unlock()
+
+	running := make(map[string]*runningWork)
+	for i, worker := range m.workers {
+		if worker == nil {
+			continue
+		}
+		if message, at := worker.current(); message != nil {
+			id := fmt.Sprintf("%s-%d", Config.queueName(m.queue), i)
+			running[id] = &runningWork{Config.queueName(m.queue), message, at}
+		}
+	}
+	return running
+}
+
+func (m *manager) size() int {
+	m.workersM.Lock()
+	defer m.workersM.Unlock()
+
+	return m.concurrency
+}
+
 func (m *manager) processing() (count int) {
 	m.workersM.Lock()
 	for _, worker := range m.workers {
-		if worker.processing() {
+		// Workers are only created once the manager starts
+		if worker != nil && worker.processing() {
 			count++
 		}
 	}
@@ -85,7 +163,7 @@ func (m *manager) processing() (count int) {
 }
 
 func (m *manager) queueName() string {
-	return strings.Replace(m.queue, "queue:", "", 1)
+	return Config.Namespace + Config.queueName(m.queue)
 }
 
 func (m *manager) reset() {
@@ -100,7 +178,7 @@ func newManager(queue string, job JobFunc, concurrency int, mids ...MiddlewareFu
 		job = NewMiddlewares(mids...).build(middlewareQueueName, job)
 	}
 	m := &manager{
-		Config.Namespace + "queue:" + queue,
+		Config.queueKey(queue),
 		nil,
 		job,
 		concurrency,
@@ -110,6 +188,7 @@ func newManager(queue string, job JobFunc, concurrency int, mids ...MiddlewareFu
 		make(chan bool),
 		make(chan bool),
 		&sync.WaitGroup{},
+		&sync.WaitGroup{},
 	}
 
 	m.fetch = Config.Fetch(m.queue)
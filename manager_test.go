@@ -231,3 +231,135 @@ func TestStopFetching(t *testing.T) {
 	len, _ := rc.LLen("prodstop:queue:manager2").Result()
 	assert.Equal(t, int64(2), len)
 }
+
+func TestAcknowledgeErrorHandlers(t *testing.T) {
+	oldBackoff := ackBackoff
+	defer func() {
+		ackBackoff = oldBackoff
+		errorHandlers = nil
+	}()
+	ackBackoff = time.Millisecond
+
+	Configure(Options{
+		ServerAddr: "localhost:1",
+		ProcessID:  "1",
+	})
+	defer setupTestConfig()
+
+	var handled []string
+	OnError(func(queue string, message *Msg, err error) {
+		handled = append(handled, queue+" "+message.Jid())
+	})
+
+	manager := newManager("manager1", nil, 1)
+	message, _ := NewMsg("{\"jid\":\"2\"}")
+
+	assert.False(t, manager.acknowledge(message))
+	assert.Equal(t, []string{"manager1 2"}, handled)
+}
+
+type slowAckFetch struct {
+	Fetcher
+	acked   chan string
+	release chan bool
+}
+
+func (f *slowAckFetch) Fetch() {}
+
+func (f *slowAckFetch) Acknowledge(message *Msg) error {
+	if message.Jid() == "slow" {
+		<-f.release
+	}
+	f.acked <- message.Jid()
+	return nil
+}
+
+func TestAcknowledgeOffManageLoop(t *testing.T) {
+	setupTestConfig()
+
+	fetch := &slowAckFetch{acked: make(chan string), release: make(chan bool)}
+	manager := newManager("manager1", nil, 1)
+	manager.fetch = fetch
+	go manager.manage()
+
+	slow, _ := NewMsg("{\"jid\":\"slow\"}")
+	fast, _ := NewMsg("{\"jid\":\"fast\"}")
+
+	//confirms other jobs while one is still being acknowledged
+	manager.confirm <- slow
+	manager.confirm <- fast
+	assert.Equal(t, "fast", <-fetch.acked)
+
+	manager.stop <- true
+	<-manager.exit
+
+	//waits for the slow acknowledgement before quitting
+	waited := make(chan bool)
+	go func() {
+		manager.acks.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("manager quit before acknowledging")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(fetch.release)
+	assert.Equal(t, "slow", <-fetch.acked)
+	<-waited
+}
+
+func TestManagerSetConcurrency(t *testing.T) {
+	setupTestConfig()
+	rc := Config.Client
+
+	started := make(chan string)
+	release := make(chan bool)
+	testJob := (func(message *Msg) error {
+		started <- message.Jid()
+		<-release
+		return nil
+	})
+
+	manager := newManager("manager4", testJob, 1)
+	manager.start()
+
+	//reports the job each worker is running
+	rc.LPush("queue:manager4", "{\"jid\":\"1\"}").Result()
+	assert.Equal(t, "1", <-started)
+
+	running := manager.running()
+	assert.Equal(t, 1, len(running))
+	assert.Equal(t, "1", running["manager4-0"].message.Jid())
+
+	//starts more workers
+	manager.setConcurrency(2)
+	assert.Equal(t, 2, manager.size())
+
+	rc.LPush("queue:manager4", "{\"jid\":\"2\"}").Result()
+	assert.Equal(t, "2", <-started)
+	assert.Equal(t, 2, manager.processing())
+
+	//stops workers once they're done
+	shrunk := make(chan bool)
+	go func() {
+		manager.setConcurrency(1)
+		shrunk <- true
+	}()
+
+	release <- true
+	release <- true
+	<-shrunk
+
+	assert.Equal(t, 1, manager.size())
+	assert.Equal(t, 1, len(manager.workers))
+
+	//doesn't start workers once quitting
+	manager.prepare()
+	manager.setConcurrency(3)
+	assert.Equal(t, 1, manager.size())
+
+	manager.quit()
+}
@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"context"
+	"sort"
+)
+
+// Metadata is extra information carried with a job, such as request or
+// tenant IDs and trace context. It's kept across retries and scheduling,
+// and is carried by the job's Context while it runs.
+type Metadata map[string]string
+
+// Get returns the value of key. With Set and Keys, it lets Metadata carry
+// context for propagators, such as OpenTelemetry's.
+func (md Metadata) Get(key string) string {
+	return md[key]
+}
+
+// Set sets key to value.
+func (md Metadata) Set(key, value string) {
+	md[key] = value
+}
+
+// Keys returns the keys that are set, sorted.
+func (md Metadata) Keys() []string {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying md, added to any metadata ctx
+// already carries. Jobs enqueued with EnqueueContext and that context, or
+// a context derived from it, get the metadata.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := Metadata{}
+	for key, value := range MetadataFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range md {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the metadata ctx carries. In a job, that's
+// the metadata the job was enqueued with.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// Metadata returns the metadata the job was enqueued with.
+func (m *Msg) Metadata() Metadata {
+	md := Metadata{}
+	fields, _ := m.Get("metadata").Map()
+	for key, value := range fields {
+		if value, ok := value.(string); ok {
+			md[key] = value
+		}
+	}
+	return md
+}
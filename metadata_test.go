@@ -0,0 +1,104 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	var enqueuedWith context.Context
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			enqueuedWith = data.Context()
+			return next(data)
+		}
+	})
+
+	//carries metadata from the context and options
+	ctx := WithMetadata(context.Background(), Metadata{"request_id": "abc", "tenant": "acme"})
+	jid, err := EnqueueContext(ctx, "metadataQueue", "Add", nil, EnqueueOptions{
+		Retry:    true,
+		Metadata: Metadata{"tenant": "other"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ctx, enqueuedWith)
+
+	payload, _ := rc.LIndex("prod:queue:metadataQueue", 0).Result()
+	message, _ := NewMsg(payload)
+	assert.Equal(t, jid, message.Jid())
+	assert.Equal(t, Metadata{"request_id": "abc", "tenant": "other"}, message.Metadata())
+
+	//puts it in the job's context
+	done := message.withCancel()
+	assert.Equal(t, "abc", MetadataFromContext(message.Context()).Get("request_id"))
+
+	//passes it on to jobs enqueued from the job
+	EnqueueContext(message.Context(), "metadataQueue", "Next", nil, EnqueueOptions{})
+	payload, _ = rc.LIndex("prod:queue:metadataQueue", 0).Result()
+	next, _ := NewMsg(payload)
+	assert.Equal(t, Metadata{"request_id": "abc", "tenant": "other"}, next.Metadata())
+	done()
+
+	//keeps it across retries
+	message, _ = NewMsg(message.OriginalJson())
+	RetryMiddleware("prod:metadataQueue", func(message *Msg) error {
+		return errors.New("AHHHH")
+	})(message)
+
+	retries, _ := RetrySet().Page(0, -1)
+	assert.Equal(t, 1, len(retries))
+	assert.Equal(t, "other", retries[0].Metadata().Get("tenant"))
+
+	//jobs without metadata have none
+	message, _ = NewMsg("{\"jid\":\"1\"}")
+	assert.Equal(t, Metadata{}, message.Metadata())
+	done = message.withCancel()
+	assert.Nil(t, MetadataFromContext(message.Context()))
+	done()
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	md := Metadata{}
+	md.Set("traceparent", "00-abc-def-01")
+	md.Set("baggage", "tenant=acme")
+
+	assert.Equal(t, "00-abc-def-01", md.Get("traceparent"))
+	assert.Equal(t, []string{"baggage", "traceparent"}, md.Keys())
+}
+
+func TestMetadataPerJob(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			data.Metadata.Set("jid", data.Jid)
+			return next(data)
+		}
+	})
+
+	//lets middlewares change each job's metadata without sharing it
+	metadata := Metadata{"tenant": "acme"}
+	jids, err := EnqueueBulk("metadataQueue", "Add", []interface{}{1, 2}, EnqueueOptions{Metadata: metadata})
+	assert.NoError(t, err)
+	assert.Equal(t, Metadata{"tenant": "acme"}, metadata)
+
+	jobs, _ := rc.LRange("prod:queue:metadataQueue", 0, -1).Result()
+	assert.Equal(t, 2, len(jobs))
+	for i, payload := range jobs {
+		message, _ := NewMsg(payload)
+		assert.Equal(t, Metadata{"tenant": "acme", "jid": jids[1-i]}, message.Metadata())
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/digitalocean/go-workers2/metrics
+
+// Builds against the root module in this repository; consumers get the
+// tagged release required below.
+replace github.com/digitalocean/go-workers2 => ../
+
+require (
+	github.com/digitalocean/go-workers2 v0.11.0
+	github.com/prometheus/client_golang v1.12.2
+	github.com/stretchr/testify v1.7.0
+)
@@ -0,0 +1,224 @@
+// Package metrics exports Prometheus metrics about jobs, queues and
+// workers.
+//
+// An Exporter counts and times jobs with its Middleware, and reads queue,
+// set and worker gauges from redis when scraped:
+//
+//	exporter := metrics.NewExporter()
+//	workers.Process("myqueue", job, 10, workers.DefaultMiddlewares().Prepend(exporter.Middleware)...)
+//	http.Handle("/metrics", exporter.Handler())
+//
+// Exporters are prometheus.Collectors too, so they can be registered with
+// other collectors instead of served with Handler.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	workers "github.com/digitalocean/go-workers2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "workers"
+
+// DurationBuckets are the buckets of the job duration histogram, in
+// seconds.
+var DurationBuckets = prometheus.DefBuckets
+
+// LatencyBuckets are the buckets of the queue latency histogram, in
+// seconds.
+var LatencyBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+// Exporter collects metrics about jobs, queues and workers.
+type Exporter struct {
+	processed *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	retried   *prometheus.CounterVec
+	dead      *prometheus.CounterVec
+	cancelled *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	latency   *prometheus.HistogramVec
+
+	busy      *prometheus.Desc
+	queueSize *prometheus.Desc
+	retries   *prometheus.Desc
+	scheduled *prometheus.Desc
+	deadSet   *prometheus.Desc
+}
+
+// NewExporter returns an Exporter with no jobs counted yet.
+func NewExporter() *Exporter {
+	labels := []string{"queue", "class"}
+
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	return &Exporter{
+		processed: counter("jobs_processed_total", "Jobs that succeeded."),
+		failed:    counter("jobs_failed_total", "Jobs that failed, whether or not they'll be retried."),
+		retried:   counter("jobs_retried_total", "Failed jobs saved for a retry."),
+		dead:      counter("jobs_dead_total", "Failed jobs moved to the dead set, out of retries."),
+		cancelled: counter("jobs_cancelled_total", "Jobs cancelled while they ran."),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "How long jobs took to run.",
+			Buckets:   DurationBuckets,
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_latency_seconds",
+			Help:      "How long jobs waited in their queue before running.",
+			Buckets:   LatencyBuckets,
+		}, labels),
+
+		busy: prometheus.NewDesc(namespace+"_busy_workers",
+			"Jobs running across all processes.", []string{"queue"}, nil),
+		queueSize: prometheus.NewDesc(namespace+"_queue_size",
+			"Jobs waiting in the queue.", []string{"queue"}, nil),
+		retries: prometheus.NewDesc(namespace+"_retry_set_size",
+			"Jobs waiting to be retried.", nil, nil),
+		scheduled: prometheus.NewDesc(namespace+"_scheduled_set_size",
+			"Jobs scheduled to run later.", nil, nil),
+		deadSet: prometheus.NewDesc(namespace+"_dead_set_size",
+			"Jobs that ran out of retries.", nil, nil),
+	}
+}
+
+// Middleware counts and times jobs. Prepend it to the other middlewares,
+// so it sees what RetryMiddleware did with failed jobs.
+func (e *Exporter) Middleware(queue string, next workers.JobFunc) workers.JobFunc {
+	queue = strings.TrimPrefix(queue, workers.Config.Namespace)
+
+	return func(message *workers.Msg) (err error) {
+		class, _ := message.Get("class").String()
+		before := workers.RetrySnapshot(message)
+		start := time.Now()
+
+		if enqueuedAt, err := message.Get("enqueued_at").Float64(); err == nil {
+			waited := start.Sub(time.Unix(0, int64(enqueuedAt*float64(time.Second))))
+			e.latency.WithLabelValues(queue, class).Observe(waited.Seconds())
+		}
+
+		defer func() {
+			e.duration.WithLabelValues(queue, class).Observe(time.Since(start).Seconds())
+
+			failure := err
+			r := recover()
+			if r != nil {
+				failure = fmt.Errorf("%v", r)
+			}
+
+			switch workers.JobOutcome(message, before, failure) {
+			case workers.StatusComplete:
+				e.processed.WithLabelValues(queue, class).Inc()
+			case workers.StatusRetrying:
+				e.failed.WithLabelValues(queue, class).Inc()
+				e.retried.WithLabelValues(queue, class).Inc()
+			case workers.StatusDead:
+				e.failed.WithLabelValues(queue, class).Inc()
+				e.dead.WithLabelValues(queue, class).Inc()
+			case workers.StatusFailed:
+				e.failed.WithLabelValues(queue, class).Inc()
+			case workers.StatusCancelled:
+				e.cancelled.WithLabelValues(queue, class).Inc()
+			}
+
+			if r != nil {
+				panic(r)
+			}
+		}()
+
+		return next(message)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.processed.Describe(ch)
+	e.failed.Describe(ch)
+	e.retried.Describe(ch)
+	e.dead.Describe(ch)
+	e.cancelled.Describe(ch)
+	e.duration.Describe(ch)
+	e.latency.Describe(ch)
+
+	ch <- e.busy
+	ch <- e.queueSize
+	ch <- e.retries
+	ch <- e.scheduled
+	ch <- e.deadSet
+}
+
+// Collect implements prometheus.Collector, reading the gauges from redis.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.processed.Collect(ch)
+	e.failed.Collect(ch)
+	e.retried.Collect(ch)
+	e.dead.Collect(ch)
+	e.cancelled.Collect(ch)
+	e.duration.Collect(ch)
+	e.latency.Collect(ch)
+
+	queues, err := workers.Queues()
+	if err != nil {
+		workers.Logger.Println("ERR: Couldn't list queues for metrics:", err)
+	}
+
+	busy := map[string]int{}
+	for _, queue := range queues {
+		busy[queue.Name()] = 0
+
+		size, err := queue.Size()
+		if err != nil {
+			workers.Logger.Println("ERR: Couldn't get the size of", queue.Name(), "for metrics:", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(e.queueSize, prometheus.GaugeValue, float64(size), queue.Name())
+	}
+
+	running, err := workers.RunningJobs()
+	if err != nil {
+		workers.Logger.Println("ERR: Couldn't list running jobs for metrics:", err)
+	} else {
+		for _, job := range running {
+			busy[job.Queue]++
+		}
+		for queue, count := range busy {
+			ch <- prometheus.MustNewConstMetric(e.busy, prometheus.GaugeValue, float64(count), queue)
+		}
+	}
+
+	sets := []struct {
+		desc *prometheus.Desc
+		set  *workers.SortedSet
+	}{
+		{e.retries, workers.RetrySet()},
+		{e.scheduled, workers.ScheduledSet()},
+		{e.deadSet, workers.DeadSet()},
+	}
+	for _, s := range sets {
+		size, err := s.set.Size()
+		if err != nil {
+			workers.Logger.Println("ERR: Couldn't get a set's size for metrics:", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, float64(size))
+	}
+}
+
+// Handler serves the exporter's metrics to Prometheus.
+func (e *Exporter) Handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	workers "github.com/digitalocean/go-workers2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestConfig() {
+	//a database of its own, as other packages' tests flush theirs
+	workers.Configure(workers.Options{
+		ServerAddr: "localhost:6379",
+		ProcessID:  "1",
+		Database:   13,
+		PoolSize:   1,
+		Namespace:  "prod",
+	})
+	workers.Config.Client.FlushDB()
+}
+
+func TestMiddleware(t *testing.T) {
+	setupTestConfig()
+	exporter := NewExporter()
+
+	job := exporter.Middleware("prod:metricsQueue", workers.RetryMiddleware("prod:metricsQueue", func(message *workers.Msg) error {
+		if class, _ := message.Get("class").String(); class == "Fail" {
+			return errors.New("AHHHH")
+		}
+		return nil
+	}))
+
+	//counts and times jobs that succeed
+	message, _ := workers.NewMsg("{\"jid\":\"1\",\"class\":\"Add\",\"enqueued_at\":1}")
+	assert.NoError(t, job(message))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.processed.WithLabelValues("metricsQueue", "Add")))
+	assert.Equal(t, 1, testutil.CollectAndCount(exporter.duration))
+	assert.Equal(t, 1, testutil.CollectAndCount(exporter.latency))
+
+	//counts retries
+	message, _ = workers.NewMsg("{\"jid\":\"2\",\"class\":\"Fail\",\"retry\":true}")
+	job(message)
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.failed.WithLabelValues("metricsQueue", "Fail")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.retried.WithLabelValues("metricsQueue", "Fail")))
+
+	//counts jobs out of retries
+	message, _ = workers.NewMsg("{\"jid\":\"3\",\"class\":\"Fail\",\"retry\":1,\"retry_count\":1}")
+	job(message)
+	assert.Equal(t, float64(2), testutil.ToFloat64(exporter.failed.WithLabelValues("metricsQueue", "Fail")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.retried.WithLabelValues("metricsQueue", "Fail")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.dead.WithLabelValues("metricsQueue", "Fail")))
+
+	//counts jobs without retries as failed
+	message, _ = workers.NewMsg("{\"jid\":\"4\",\"class\":\"Fail\"}")
+	assert.Error(t, job(message))
+	assert.Equal(t, float64(3), testutil.ToFloat64(exporter.failed.WithLabelValues("metricsQueue", "Fail")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.dead.WithLabelValues("metricsQueue", "Fail")))
+
+	//counts panics as failures
+	job = exporter.Middleware("prod:metricsQueue", func(message *workers.Msg) error {
+		panic("AHHHH")
+	})
+	message, _ = workers.NewMsg("{\"jid\":\"5\",\"class\":\"Panic\"}")
+	assert.Panics(t, func() {
+		job(message)
+	})
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.failed.WithLabelValues("metricsQueue", "Panic")))
+	assert.Equal(t, 3, testutil.CollectAndCount(exporter.duration))
+}
+
+func TestHandler(t *testing.T) {
+	setupTestConfig()
+	exporter := NewExporter()
+
+	workers.Enqueue("metricsQueue", "Add", []int{1, 2})
+	workers.Enqueue("metricsQueue", "Add", []int{3, 4})
+	workers.EnqueueIn("metricsQueue", "Add", 60, []int{5, 6})
+
+	message, _ := workers.NewMsg("{\"jid\":\"1\",\"class\":\"Fail\",\"retry\":true}")
+	workers.RetryMiddleware("prod:metricsQueue", func(message *workers.Msg) error {
+		return errors.New("AHHHH")
+	})(message)
+
+	//serves counters and gauges read from redis
+	exporter.processed.WithLabelValues("metricsQueue", "Add").Inc()
+
+	recorder := httptest.NewRecorder()
+	exporter.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, string(body), "workers_jobs_processed_total{class=\"Add\",queue=\"metricsQueue\"} 1\n")
+	assert.Contains(t, string(body), "workers_queue_size{queue=\"metricsQueue\"} 2\n")
+	assert.Contains(t, string(body), "workers_busy_workers{queue=\"metricsQueue\"} 0\n")
+	assert.Contains(t, string(body), "workers_scheduled_set_size 1\n")
+	assert.Contains(t, string(body), "workers_retry_set_size 1\n")
+	assert.Contains(t, string(body), "workers_dead_set_size 0\n")
+}
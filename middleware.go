@@ -28,6 +28,10 @@ func NewMiddlewares(mids ...MiddlewareFunc) Middlewares {
 // This is a variable for testing reasons
 var defaultMiddlewares = NewMiddlewares(
 	LogMiddleware,
+	StatusMiddleware,
+	ResultMiddleware,
+	BatchMiddleware,
+	WorkflowMiddleware,
 	RetryMiddleware,
 	StatsMiddleware,
 )
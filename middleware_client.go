@@ -0,0 +1,48 @@
+package workers
+
+import "sync"
+
+// EnqueueFunc pushes a job.
+type EnqueueFunc func(data *EnqueueData) error
+
+// ClientMiddlewareFunc wraps enqueueing jobs, like MiddlewareFunc wraps
+// running them. It can change the job, such as adding metadata or moving
+// it to another queue, reject it by returning an error, or drop it by
+// returning without calling next.
+type ClientMiddlewareFunc func(next EnqueueFunc) EnqueueFunc
+
+type ClientMiddlewares []ClientMiddlewareFunc
+
+func (ms ClientMiddlewares) build(final EnqueueFunc) EnqueueFunc {
+	for i := len(ms) - 1; i >= 0; i-- {
+		final = ms[i](final)
+	}
+	return final
+}
+
+var clientMiddlewares ClientMiddlewares
+var clientMiddlewaresM sync.RWMutex
+
+// UseClientMiddleware adds middlewares that every enqueued job goes
+// through, including jobs enqueued by batches, workflows and outboxes.
+// They run in the order they're added.
+func UseClientMiddleware(mids ...ClientMiddlewareFunc) {
+	clientMiddlewaresM.Lock()
+	defer clientMiddlewaresM.Unlock()
+	clientMiddlewares = append(clientMiddlewares, mids...)
+}
+
+// runClientMiddlewares runs a job through the client middlewares and into
+// push, reporting whether it got there.
+func runClientMiddlewares(data *EnqueueData, push EnqueueFunc) (pushed bool, err error) {
+	clientMiddlewaresM.RLock()
+	mids := clientMiddlewares
+	clientMiddlewaresM.RUnlock()
+
+	err = mids.build(func(data *EnqueueData) error {
+		pushed = true
+		return push(data)
+	})(data)
+
+	return pushed, err
+}
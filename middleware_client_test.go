@@ -0,0 +1,118 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientMiddleware(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	var order []string
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			order = append(order, "first")
+			if data.Class == "Reject" {
+				return errors.New("rejected")
+			}
+			if data.Class == "Drop" {
+				return nil
+			}
+			return next(data)
+		}
+	}, func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			order = append(order, "second")
+			if data.Queue == "old" {
+				data.Queue = "new"
+				data.Retry = true
+			}
+			return next(data)
+		}
+	})
+
+	//runs middlewares in order and lets them change jobs
+	jid, err := Enqueue("old", "Add", []int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	payload, _ := rc.LIndex("prod:queue:new", 0).Result()
+	message, _ := NewMsg(payload)
+	assert.Equal(t, jid, message.Jid())
+	assert.True(t, message.Get("retry").MustBool())
+
+	size, _ := rc.LLen("prod:queue:old").Result()
+	assert.Equal(t, int64(0), size)
+
+	//rejects jobs
+	jid, err = EnqueueIn("clientQueue", "Reject", 60, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "", jid)
+
+	//drops jobs
+	jid, err = Enqueue("clientQueue", "Drop", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", jid)
+
+	scheduled, _ := ScheduledSet().Size()
+	assert.Equal(t, int64(0), scheduled)
+	size, _ = rc.LLen("prod:queue:clientQueue").Result()
+	assert.Equal(t, int64(0), size)
+}
+
+func TestEnqueueBulk(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			if data.Args == 2 {
+				return nil
+			}
+			if data.Args == 4 {
+				return errors.New("rejected")
+			}
+			return next(data)
+		}
+	})
+
+	//enqueues every job but the dropped ones
+	jids, err := EnqueueBulk("bulkQueue", "Add", []interface{}{1, 2, 3}, EnqueueOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(jids))
+	assert.NotEmpty(t, jids[0])
+	assert.Empty(t, jids[1])
+	assert.NotEmpty(t, jids[2])
+
+	jobs, _ := rc.LRange("prod:queue:bulkQueue", 0, -1).Result()
+	assert.Equal(t, 2, len(jobs))
+	message, _ := NewMsg(jobs[0])
+	assert.Equal(t, jids[2], message.Jid())
+
+	found, _ := rc.SIsMember("prod:queues", "bulkQueue").Result()
+	assert.True(t, found)
+
+	//enqueues none if one is rejected, nor tracks their status
+	_, err = EnqueueBulk("bulkQueue", "Add", []interface{}{5, 4}, EnqueueOptions{TrackStatus: true})
+	assert.Error(t, err)
+
+	size, _ := rc.LLen("prod:queue:bulkQueue").Result()
+	assert.Equal(t, int64(2), size)
+
+	statuses, _ := rc.Keys("prod:" + STATUS_KEY + ":*").Result()
+	assert.Equal(t, 0, len(statuses))
+
+	//schedules jobs due later
+	EnqueueBulk("bulkQueue", "Add", []interface{}{1}, EnqueueOptions{At: nowToSecondsWithNanoPrecision() + 60})
+	scheduled, _ := ScheduledSet().Size()
+	assert.Equal(t, int64(1), scheduled)
+}
@@ -41,6 +41,11 @@ func LogMiddleware(queue string, next JobFunc) JobFunc {
 }
 
 func logProcessError(prefix string, start time.Time, err error) {
+	if err == ErrCancelled {
+		Logger.Println(prefix, "cancelled:", time.Since(start))
+		return
+	}
+
 	Logger.Println(prefix, "fail:", time.Since(start))
 
 	buf := make([]byte, 4096)
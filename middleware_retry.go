@@ -14,7 +14,15 @@ const (
 	RetryTimeFormat = "2006-01-02 15:04:05 MST"
 )
 
+// These are variables for testing reasons
+var deadMaxJobs int64 = 10000
+var deadTimeout = 180 * 24 * time.Hour
+
 func retryProcessError(queue string, message *Msg, err error) error {
+	if message.cancelled() {
+		return ErrCancelled
+	}
+
 	if retry(message) {
 		message.Set("queue", queue)
 		message.Set("error_message", fmt.Sprintf("%v", err))
@@ -38,10 +46,35 @@ func retryProcessError(queue string, message *Msg, err error) error {
 		if err != nil {
 			message.ack = false
 		}
+	} else if enabled, _ := retryOptions(message); enabled {
+		message.Set("queue", queue)
+		message.Set("error_message", fmt.Sprintf("%v", err))
+
+		// Same as above, keep the job in progress rather than lose it.
+		if deadErr := kill(message); deadErr != nil {
+			Logger.Println("ERR: Couldn't move", message.Jid(), "to the dead set:", deadErr)
+			message.ack = false
+		}
 	}
 	return err
 }
 
+// kill moves a job that has run out of retries to the dead set, trimming
+// the set to deadMaxJobs jobs and dropping jobs older than deadTimeout.
+func kill(message *Msg) error {
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+	key := Config.Namespace + DEAD_KEY
+
+	pipe := rc.TxPipeline()
+	pipe.ZAdd(key, redis.Z{Score: now, Member: message.ToJson()})
+	pipe.ZRemRangeByScore(key, "-inf", fmt.Sprint(now-durationToSecondsWithNanoPrecision(deadTimeout)))
+	pipe.ZRemRangeByRank(key, 0, -(deadMaxJobs + 1))
+	_, err := pipe.Exec()
+	return err
+}
+
 func RetryMiddleware(queue string, next JobFunc) JobFunc {
 	return func(message *Msg) (err error) {
 		defer func() {
@@ -68,8 +101,15 @@ func RetryMiddleware(queue string, next JobFunc) JobFunc {
 }
 
 func retry(message *Msg) bool {
-	retry := false
-	max := DefaultRetryMax
+	retry, max := retryOptions(message)
+
+	count, _ := message.Get("retry_count").Int()
+
+	return retry && count < max
+}
+
+func retryOptions(message *Msg) (retry bool, max int) {
+	max = DefaultRetryMax
 
 	if param, err := message.Get("retry").Bool(); err == nil {
 		retry = param
@@ -78,9 +118,7 @@ func retry(message *Msg) bool {
 		retry = true
 	}
 
-	count, _ := message.Get("retry_count").Int()
-
-	return retry && count < max
+	return
 }
 
 func incrementRetry(message *Msg) (retryCount int) {
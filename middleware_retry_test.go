@@ -223,3 +223,49 @@ func TestRetryOnlyToCustomMax(t *testing.T) {
 	count, _ := rc.ZCard("prod:" + RETRY_KEY).Result()
 	assert.Equal(t, int64(0), count)
 }
+
+func TestDeadSet(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	oldMax := deadMaxJobs
+	defer func() {
+		deadMaxJobs = oldMax
+	}()
+	deadMaxJobs = 2
+
+	manager := newManager("myqueue", panicingJob, 1)
+	worker := newWorker(manager)
+
+	rc := Config.Client
+
+	//moves jobs that run out of retries to the dead set
+	for _, jid := range []string{"1", "2", "3"} {
+		message, _ := NewMsg("{\"jid\":\"" + jid + "\",\"retry\":3,\"retry_count\":3}")
+
+		wares.build("myqueue", func(message *Msg) error {
+			worker.process(message)
+			return nil
+		})(message)
+	}
+
+	retries, _ := rc.ZCard("prod:" + RETRY_KEY).Result()
+	assert.Equal(t, int64(0), retries)
+
+	//keeps only the newest jobs
+	dead, _ := rc.ZRange("prod:"+DEAD_KEY, 0, -1).Result()
+	assert.Equal(t, 2, len(dead))
+
+	message, _ := NewMsg(dead[1])
+	error_message, _ := message.Get("error_message").String()
+	assert.Equal(t, "3", message.Jid())
+	assert.Equal(t, "AHHHH", error_message)
+
+	//doesn't keep jobs that never retry
+	message, _ = NewMsg("{\"jid\":\"4\",\"retry\":false}")
+	wares.build("myqueue", func(message *Msg) error {
+		worker.process(message)
+		return nil
+	})(message)
+
+	count, _ := rc.ZCard("prod:" + DEAD_KEY).Result()
+	assert.Equal(t, int64(2), count)
+}
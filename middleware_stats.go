@@ -15,7 +15,7 @@ func StatsMiddleware(queue string, next JobFunc) JobFunc {
 				}
 
 				if err != nil {
-					incrementStats("failed")
+					incrementFailedStats(message)
 				}
 			}
 
@@ -23,7 +23,7 @@ func StatsMiddleware(queue string, next JobFunc) JobFunc {
 
 		err = next(message)
 		if err != nil {
-			incrementStats("failed")
+			incrementFailedStats(message)
 		} else {
 			incrementStats("processed")
 		}
@@ -32,6 +32,16 @@ func StatsMiddleware(queue string, next JobFunc) JobFunc {
 	}
 }
 
+// incrementFailedStats counts jobs that returned an error after being
+// cancelled separately from real failures.
+func incrementFailedStats(message *Msg) {
+	if message.cancelled() {
+		incrementStats("cancelled")
+	} else {
+		incrementStats("failed")
+	}
+}
+
 func incrementStats(metric string) {
 	rc := Config.Client
 
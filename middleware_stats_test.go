@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -76,3 +77,29 @@ func TestFailedStats(t *testing.T) {
 	dayCountInt, _ = strconv.ParseInt(dayCount, 10, 64)
 	assert.Equal(t, int64(1), dayCountInt)
 }
+
+func TestStatsTotals(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	rc.Set("prod:stat:processed", "10", 0).Result()
+	rc.Set("prod:stat:failed", "3", 0).Result()
+	rc.Set("prod:stat:expired", "2", 0).Result()
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: 1, Member: "{}"}).Result()
+	rc.ZAdd("prod:"+DEAD_KEY, redis.Z{Score: 1, Member: "{}"}).Result()
+	EnqueueIn("default", "Add", 60, []int{1})
+	Enqueue("default", "Add", []int{1})
+	Enqueue("default", "Add", []int{2})
+	Enqueue("other", "Add", []int{1})
+
+	totals, err := StatsTotals()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), totals.Processed)
+	assert.Equal(t, int64(3), totals.Failed)
+	assert.Equal(t, int64(2), totals.Expired)
+	assert.Equal(t, int64(1), totals.Scheduled)
+	assert.Equal(t, int64(1), totals.Retries)
+	assert.Equal(t, int64(1), totals.Dead)
+	assert.Equal(t, int64(0), totals.Quarantined)
+	assert.Equal(t, map[string]int64{"default": 2, "other": 1}, totals.Enqueued)
+}
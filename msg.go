@@ -1,8 +1,10 @@
 package workers
 
 import (
-	"github.com/bitly/go-simplejson"
+	"context"
 	"reflect"
+
+	"github.com/bitly/go-simplejson"
 )
 
 type data struct {
@@ -13,6 +15,10 @@ type Msg struct {
 	*data
 	original string
 	ack      bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	result interface{}
 }
 
 type Args struct {
@@ -32,6 +38,28 @@ func (m *Msg) Args() *Args {
 	}
 }
 
+// Context is cancelled when the job is cancelled with Cancel while it's
+// running. Long running jobs should stop when it's done. It carries the
+// job's metadata, so jobs enqueued with EnqueueContext and it inherit it.
+func (m *Msg) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// SetContext replaces the job's Context, for middlewares that add values to
+// it. ctx should be derived from Context, so the job can still be
+// cancelled.
+func (m *Msg) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// cancelled reports whether the job was cancelled while it was running.
+func (m *Msg) cancelled() bool {
+	return m.ctx != nil && m.ctx.Err() != nil
+}
+
 func (m *Msg) OriginalJson() string {
 	return m.original
 }
@@ -55,7 +83,7 @@ func NewMsg(content string) (*Msg, error) {
 	if d, err := newData(content); err != nil {
 		return nil, err
 	} else {
-		return &Msg{d, content, true}, nil
+		return &Msg{data: d, original: content, ack: true}, nil
 	}
 }
 
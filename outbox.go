@@ -0,0 +1,209 @@
+package workers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// These are variables for testing reasons
+var outboxPollInterval = time.Second
+var outboxClaimTimeout = time.Minute
+var outboxBatchSize = 100
+
+// Outbox enqueues jobs in the same database transaction as the changes
+// they're about, so the job exists if and only if the transaction commits.
+// Jobs are written to a table with Enqueue, and relayed to redis with
+// Relay, or Start to relay them in the background.
+//
+// Jobs are relayed at least once: a relay that dies between pushing a job
+// and marking it sent pushes it again once its claim times out.
+type Outbox struct {
+	DB *sql.DB
+
+	// Table is the outbox table, workers_outbox by default.
+	Table string
+
+	// Placeholder returns the nth query parameter, counting from 1. It
+	// defaults to ?, use PostgresPlaceholder for PostgreSQL.
+	Placeholder func(n int) string
+
+	stop chan bool
+	exit chan bool
+}
+
+// NewOutbox returns an outbox stored in db.
+func NewOutbox(db *sql.DB) *Outbox {
+	return &Outbox{
+		DB:    db,
+		Table: "workers_outbox",
+		Placeholder: func(n int) string {
+			return "?"
+		},
+	}
+}
+
+// PostgresPlaceholder numbers query parameters like PostgreSQL expects.
+func PostgresPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// CreateTable creates the outbox table if it doesn't exist.
+func (o *Outbox) CreateTable() error {
+	_, err := o.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + o.Table + ` (
+		jid VARCHAR(24) PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at DOUBLE PRECISION NOT NULL,
+		claimed_until DOUBLE PRECISION,
+		sent_at DOUBLE PRECISION
+	)`)
+	return err
+}
+
+// Enqueue writes a job to the outbox within tx, with the same payload
+// EnqueueWithOptions would push, after running it through the client
+// middlewares. It's relayed once tx commits, and never if tx rolls back.
+func (o *Outbox) Enqueue(tx *sql.Tx, queue, class string, args interface{}, opts EnqueueOptions) (string, error) {
+	data := newEnqueueData(queue, class, args, opts)
+
+	pushed, err := runClientMiddlewares(data, func(data *EnqueueData) error {
+		bytes, err := encodeJob(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(o.query("INSERT INTO %s (jid, payload, created_at) VALUES (%s, %s, %s)"),
+			data.Jid, string(bytes), data.EnqueuedAt)
+		return err
+	})
+	if err != nil || !pushed {
+		return "", err
+	}
+
+	return data.Jid, nil
+}
+
+// Relay pushes the jobs waiting in the outbox to redis, oldest first,
+// returning how many it pushed.
+func (o *Outbox) Relay() (int, error) {
+	relayed := 0
+
+	for {
+		now := nowToSecondsWithNanoPrecision()
+
+		rows, err := o.DB.Query(o.query("SELECT jid, payload FROM %s WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < %s) ORDER BY created_at LIMIT %s"),
+			now, outboxBatchSize)
+		if err != nil {
+			return relayed, err
+		}
+
+		payloads := make(map[string]string)
+		var jids []string
+		for rows.Next() {
+			var jid, payload string
+			if err := rows.Scan(&jid, &payload); err != nil {
+				rows.Close()
+				return relayed, err
+			}
+			jids = append(jids, jid)
+			payloads[jid] = payload
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return relayed, err
+		}
+
+		for _, jid := range jids {
+			sent, err := o.relay(jid, payloads[jid], now)
+			if err != nil {
+				return relayed, err
+			}
+			if sent {
+				relayed++
+			}
+		}
+
+		if len(jids) < outboxBatchSize {
+			return relayed, nil
+		}
+	}
+}
+
+// relay claims a job so other relays leave it alone, pushes it and marks it
+// sent. Returns false if another relay claimed it first.
+func (o *Outbox) relay(jid, payload string, now float64) (bool, error) {
+	claim, err := o.DB.Exec(o.query("UPDATE %s SET claimed_until = %s WHERE jid = %s AND sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < %s)"),
+		now+durationToSecondsWithNanoPrecision(outboxClaimTimeout), jid, now)
+	if err != nil {
+		return false, err
+	}
+	if claimed, err := claim.RowsAffected(); err != nil || claimed == 0 {
+		return false, err
+	}
+
+	var data EnqueueData
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return false, err
+	}
+
+	if err := enqueuePayload(data, []byte(payload)); err != nil {
+		// Let the next pass retry it straight away
+		o.DB.Exec(o.query("UPDATE %s SET claimed_until = NULL WHERE jid = %s"), jid)
+		return false, err
+	}
+
+	_, err = o.DB.Exec(o.query("UPDATE %s SET sent_at = %s WHERE jid = %s"),
+		nowToSecondsWithNanoPrecision(), jid)
+	return err == nil, err
+}
+
+// Purge deletes the jobs that were sent more than age ago.
+func (o *Outbox) Purge(age time.Duration) (int64, error) {
+	before := nowToSecondsWithNanoPrecision() - durationToSecondsWithNanoPrecision(age)
+
+	result, err := o.DB.Exec(o.query("DELETE FROM %s WHERE sent_at < %s"), before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Start relays jobs in the background until Stop is called.
+func (o *Outbox) Start() {
+	o.stop = make(chan bool)
+	o.exit = make(chan bool)
+
+	go func() {
+		defer close(o.exit)
+
+		for {
+			if _, err := o.Relay(); err != nil {
+				Logger.Println("ERR: Couldn't relay outbox", o.Table, ":", err)
+			}
+
+			select {
+			case <-o.stop:
+				return
+			case <-time.After(outboxPollInterval):
+			}
+		}
+	}()
+}
+
+// Stop waits for the current pass to finish and stops relaying jobs.
+func (o *Outbox) Stop() {
+	close(o.stop)
+	<-o.exit
+}
+
+// query fills in the table name and numbered placeholders of a query
+// written with %s for each.
+func (o *Outbox) query(format string) string {
+	args := []interface{}{o.Table}
+	for i := 1; i < strings.Count(format, "%s"); i++ {
+		args = append(args, o.Placeholder(i))
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,162 @@
+//go:build sqlite
+// +build sqlite
+
+// The outbox tests need a database, and use sqlite so they don't need a
+// server. go-sqlite3 needs cgo and isn't a requirement of this module, so
+// they only run with the sqlite tag, as CI does:
+//
+//	go get github.com/mattn/go-sqlite3@v1.14.6 && go test -tags sqlite -run Outbox
+
+package workers
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func testOutbox(t *testing.T) (*Outbox, func()) {
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "outbox.db"))
+	assert.NoError(t, err)
+
+	outbox := NewOutbox(db)
+	assert.NoError(t, outbox.CreateTable())
+	return outbox, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	outbox, cleanup := testOutbox(t)
+	defer cleanup()
+	defer outbox.DB.Close()
+
+	tx, _ := outbox.DB.Begin()
+	jid, err := outbox.Enqueue(tx, "outboxQueue", "Add", []int{1, 2}, EnqueueOptions{Retry: true, TrackStatus: true})
+	assert.NoError(t, err)
+
+	//doesn't relay jobs until the transaction commits
+	relayed, err := outbox.Relay()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, relayed)
+
+	assert.NoError(t, tx.Commit())
+
+	relayed, err = outbox.Relay()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, relayed)
+
+	//pushes the usual payload
+	payload, _ := rc.LIndex("prod:queue:outboxQueue", 0).Result()
+	message, _ := NewMsg(payload)
+	assert.Equal(t, jid, message.Jid())
+	assert.Equal(t, "Add", message.Get("class").MustString())
+	assert.Equal(t, "[1,2]", message.Args().ToJson())
+	assert.True(t, message.Get("retry").MustBool())
+
+	status, _ := Status(jid)
+	assert.Equal(t, StatusQueued, status.Status)
+
+	//marks jobs sent so they're only relayed once
+	relayed, _ = outbox.Relay()
+	assert.Equal(t, 0, relayed)
+
+	size, _ := rc.LLen("prod:queue:outboxQueue").Result()
+	assert.Equal(t, int64(1), size)
+
+	var sentAt sql.NullFloat64
+	outbox.DB.QueryRow("SELECT sent_at FROM workers_outbox WHERE jid = ?", jid).Scan(&sentAt)
+	assert.True(t, sentAt.Valid)
+
+	//never relays jobs from rolled back transactions
+	tx, _ = outbox.DB.Begin()
+	outbox.Enqueue(tx, "outboxQueue", "Add", []int{3, 4}, EnqueueOptions{})
+	assert.NoError(t, tx.Rollback())
+
+	relayed, _ = outbox.Relay()
+	assert.Equal(t, 0, relayed)
+
+	//purges sent jobs
+	purged, err := outbox.Purge(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestOutboxScheduledJobs(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	outbox, cleanup := testOutbox(t)
+	defer cleanup()
+	defer outbox.DB.Close()
+
+	tx, _ := outbox.DB.Begin()
+	outbox.Enqueue(tx, "outboxQueue", "Add", nil, EnqueueOptions{At: nowToSecondsWithNanoPrecision() + 60})
+	tx.Commit()
+
+	outbox.Relay()
+
+	scheduled, _ := ScheduledSet().Size()
+	assert.Equal(t, int64(1), scheduled)
+}
+
+func TestOutboxSkipsClaimedJobs(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	outbox, cleanup := testOutbox(t)
+	defer cleanup()
+	defer outbox.DB.Close()
+
+	tx, _ := outbox.DB.Begin()
+	jid, _ := outbox.Enqueue(tx, "outboxQueue", "Add", nil, EnqueueOptions{})
+	tx.Commit()
+
+	//leaves jobs claimed by another relay alone until the claim times out
+	outbox.DB.Exec("UPDATE workers_outbox SET claimed_until = ? WHERE jid = ?", nowToSecondsWithNanoPrecision()+60, jid)
+	relayed, _ := outbox.Relay()
+	assert.Equal(t, 0, relayed)
+
+	outbox.DB.Exec("UPDATE workers_outbox SET claimed_until = ? WHERE jid = ?", nowToSecondsWithNanoPrecision()-1, jid)
+	relayed, _ = outbox.Relay()
+	assert.Equal(t, 1, relayed)
+}
+
+func TestOutboxRelaysInBackground(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	oldInterval := outboxPollInterval
+	defer func() {
+		outboxPollInterval = oldInterval
+	}()
+	outboxPollInterval = 10 * time.Millisecond
+
+	outbox, cleanup := testOutbox(t)
+	defer cleanup()
+	defer outbox.DB.Close()
+
+	outbox.Start()
+
+	tx, _ := outbox.DB.Begin()
+	outbox.Enqueue(tx, "outboxQueue", "Add", nil, EnqueueOptions{})
+	tx.Commit()
+
+	var size int64
+	for i := 0; i < 100 && size == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		size, _ = Config.Client.LLen("prod:queue:outboxQueue").Result()
+	}
+	assert.Equal(t, int64(1), size)
+
+	outbox.Stop()
+}
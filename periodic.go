@@ -0,0 +1,284 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// These are variables for testing reasons
+var periodicPollInterval = 10 * time.Second
+var periodicCatchUp = 24 * time.Hour
+
+// PeriodicJob is a job enqueued on a cron schedule. Definitions are stored
+// in redis, so every process sees jobs registered by any other, and each
+// tick is enqueued exactly once across all processes.
+type PeriodicJob struct {
+	// Name uniquely identifies the job; registering the same name again
+	// replaces its definition.
+	Name string `json:"name"`
+
+	// Spec is a standard 5 field cron expression, or one of @yearly,
+	// @monthly, @weekly, @daily or @hourly.
+	Spec string `json:"spec"`
+
+	// TimeZone is an IANA time zone name the spec is evaluated in.
+	// Defaults to UTC.
+	TimeZone string `json:"time_zone,omitempty"`
+
+	Queue   string         `json:"queue"`
+	Class   string         `json:"class"`
+	Args    interface{}    `json:"args"`
+	Options EnqueueOptions `json:"options"`
+
+	// These are filled in by PeriodicJobs.
+	Enabled   bool      `json:"-"`
+	LastRunAt time.Time `json:"-"`
+	NextRunAt time.Time `json:"-"`
+}
+
+func (j *PeriodicJob) schedule() (*cronSchedule, error) {
+	location := time.UTC
+	if j.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(j.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseCron(j.Spec, location)
+}
+
+// RegisterPeriodicJob stores a periodic job definition, replacing any
+// existing job with the same name. A newly registered job first runs at the
+// next tick after registration; re-registering keeps its last run time and
+// whether it's enabled.
+func RegisterPeriodicJob(job PeriodicJob) error {
+	if job.Name == "" || job.Queue == "" || job.Class == "" {
+		return errors.New("periodic jobs require a Name, Queue and Class")
+	}
+
+	if _, err := job.schedule(); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	rc := Config.Client
+
+	pipe := rc.TxPipeline()
+	pipe.HSet(Config.Namespace+PERIODIC_KEY, job.Name, bytes)
+	pipe.HSetNX(Config.Namespace+PERIODIC_LAST_RUN_KEY, job.Name, time.Now().Unix())
+	_, err = pipe.Exec()
+	return err
+}
+
+// DeletePeriodicJob removes a periodic job definition.
+func DeletePeriodicJob(name string) error {
+	rc := Config.Client
+
+	pipe := rc.TxPipeline()
+	pipe.HDel(Config.Namespace+PERIODIC_KEY, name)
+	pipe.HDel(Config.Namespace+PERIODIC_LAST_RUN_KEY, name)
+	pipe.SRem(Config.Namespace+PERIODIC_DISABLED_KEY, name)
+	_, err := pipe.Exec()
+	return err
+}
+
+// EnablePeriodicJob resumes enqueueing a disabled periodic job.
+func EnablePeriodicJob(name string) error {
+	return Config.Client.SRem(Config.Namespace+PERIODIC_DISABLED_KEY, name).Err()
+}
+
+// DisablePeriodicJob stops enqueueing a periodic job until it's enabled
+// again, across all processes.
+func DisablePeriodicJob(name string) error {
+	exists, err := Config.Client.HExists(Config.Namespace+PERIODIC_KEY, name).Result()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("no periodic job named " + name)
+	}
+
+	return Config.Client.SAdd(Config.Namespace+PERIODIC_DISABLED_KEY, name).Err()
+}
+
+// PeriodicJobs returns every registered periodic job, sorted by name.
+func PeriodicJobs() ([]*PeriodicJob, error) {
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	definitions := pipe.HGetAll(Config.Namespace + PERIODIC_KEY)
+	lastRuns := pipe.HGetAll(Config.Namespace + PERIODIC_LAST_RUN_KEY)
+	disabled := pipe.SMembers(Config.Namespace + PERIODIC_DISABLED_KEY)
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+
+	isDisabled := make(map[string]bool)
+	for _, name := range disabled.Val() {
+		isDisabled[name] = true
+	}
+
+	jobs := make([]*PeriodicJob, 0, len(definitions.Val()))
+	for name, definition := range definitions.Val() {
+		job := &PeriodicJob{}
+		if err := json.Unmarshal([]byte(definition), job); err != nil {
+			Logger.Println("ERR: Couldn't decode periodic job", name, ":", err)
+			continue
+		}
+
+		job.Enabled = !isDisabled[name]
+
+		var lastRun int64
+		if err := json.Unmarshal([]byte(lastRuns.Val()[name]), &lastRun); err == nil {
+			job.LastRunAt = time.Unix(lastRun, 0)
+		}
+
+		if schedule, err := job.schedule(); err == nil {
+			job.NextRunAt, _ = schedule.next(job.LastRunAt)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs, nil
+}
+
+// claimTickScript records ARGV[2] as the last run of the periodic job
+// ARGV[1] in the hash KEYS[1], unless it already ran at or after that tick.
+// Only the process that claims the tick enqueues the job; it gets back the
+// previous last run, or 0 if there wasn't one, and others get -1.
+var claimTickScript = redis.NewScript(`
+local last = tonumber(redis.call('hget', KEYS[1], ARGV[1]) or '0')
+if last >= tonumber(ARGV[2]) then
+	return -1
+end
+redis.call('hset', KEYS[1], ARGV[1], ARGV[2])
+return last
+`)
+
+// releaseTickScript gives up the claim on tick ARGV[2] of the periodic job
+// ARGV[1], restoring the previous last run ARGV[3], unless a later tick has
+// been claimed since.
+var releaseTickScript = redis.NewScript(`
+if redis.call('hget', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == '0' then
+	redis.call('hdel', KEYS[1], ARGV[1])
+else
+	redis.call('hset', KEYS[1], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+type periodic struct {
+	backoff *backoff
+	errors  *logLimiter
+	closed  chan bool
+}
+
+func (p *periodic) start() {
+	go (func() {
+		for {
+			wait := periodicPollInterval
+			if err := p.poll(); err != nil {
+				p.errors.Println("ERR: couldn't poll periodic jobs:", err)
+				wait = p.backoff.next()
+			} else {
+				p.backoff.reset()
+			}
+
+			select {
+			case <-p.closed:
+				return
+			case <-time.After(wait):
+			}
+		}
+	})()
+}
+
+func (p *periodic) quit() {
+	close(p.closed)
+}
+
+func (p *periodic) poll() error {
+	jobs, err := PeriodicJobs()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		if !job.Enabled {
+			continue
+		}
+
+		if err := p.enqueueDue(job, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueDue enqueues a job once if at least one of its ticks has passed
+// since it last ran. Ticks missed while no process was running are
+// collapsed into one, and only looked for within periodicCatchUp.
+func (p *periodic) enqueueDue(job *PeriodicJob, now time.Time) error {
+	schedule, err := job.schedule()
+	if err != nil {
+		Logger.Println("ERR: Couldn't parse periodic job", job.Name, ":", err)
+		return nil
+	}
+
+	from := job.LastRunAt
+	if earliest := now.Add(-periodicCatchUp); from.Before(earliest) {
+		from = earliest
+	}
+
+	var tick time.Time
+	for {
+		next, err := schedule.next(from)
+		if err != nil || next.After(now) {
+			break
+		}
+		tick, from = next, next
+	}
+
+	if tick.IsZero() {
+		return nil
+	}
+
+	rc := Config.Client
+
+	key := Config.Namespace + PERIODIC_LAST_RUN_KEY
+	last, err := claimTickScript.Run(rc, []string{key}, job.Name, tick.Unix()).Int64()
+	if err != nil || last < 0 {
+		return err
+	}
+
+	opts := job.Options
+	opts.At = 0
+	if _, err = EnqueueWithOptions(job.Queue, job.Class, job.Args, opts); err != nil {
+		// Give the tick back, so it's enqueued on the next poll.
+		if _, releaseErr := releaseTickScript.Run(rc, []string{key}, job.Name, tick.Unix(), last).Result(); releaseErr != nil {
+			Logger.Println("ERR: Couldn't release periodic job", job.Name, "after failing to enqueue it:", releaseErr)
+		}
+	}
+	return err
+}
+
+func newPeriodic() *periodic {
+	return &periodic{newBackoff(), newLogLimiter(), make(chan bool)}
+}
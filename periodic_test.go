@@ -0,0 +1,146 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterPeriodicJob(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	err := RegisterPeriodicJob(PeriodicJob{
+		Name:     "report",
+		Spec:     "0 9 * * *",
+		TimeZone: "America/New_York",
+		Queue:    "reports",
+		Class:    "DailyReport",
+		Args:     []string{"daily"},
+	})
+	assert.NoError(t, err)
+
+	//lists registered jobs
+	jobs, err := PeriodicJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "report", jobs[0].Name)
+	assert.True(t, jobs[0].Enabled)
+	assert.InDelta(t, time.Now().Unix(), jobs[0].LastRunAt.Unix(), 1)
+	assert.True(t, jobs[0].NextRunAt.After(time.Now()))
+
+	//disables and enables jobs
+	assert.NoError(t, DisablePeriodicJob("report"))
+	jobs, _ = PeriodicJobs()
+	assert.False(t, jobs[0].Enabled)
+
+	assert.NoError(t, EnablePeriodicJob("report"))
+	jobs, _ = PeriodicJobs()
+	assert.True(t, jobs[0].Enabled)
+
+	assert.Error(t, DisablePeriodicJob("missing"))
+
+	//rejects invalid definitions
+	assert.Error(t, RegisterPeriodicJob(PeriodicJob{Name: "bad", Spec: "* *", Queue: "q", Class: "C"}))
+	assert.Error(t, RegisterPeriodicJob(PeriodicJob{Name: "bad", Spec: "* * * * *", TimeZone: "Nowhere/Else", Queue: "q", Class: "C"}))
+	assert.Error(t, RegisterPeriodicJob(PeriodicJob{Name: "bad", Spec: "* * * * *"}))
+
+	//deletes jobs
+	assert.NoError(t, DeletePeriodicJob("report"))
+	jobs, _ = PeriodicJobs()
+	assert.Equal(t, 0, len(jobs))
+}
+
+func TestPeriodicEnqueuesOncePerTick(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	err := RegisterPeriodicJob(PeriodicJob{
+		Name:    "cleanup",
+		Spec:    "* * * * *",
+		Queue:   "maintenance",
+		Class:   "Cleanup",
+		Args:    []int{1},
+		Options: EnqueueOptions{Retry: true},
+	})
+	assert.NoError(t, err)
+
+	// pretend it last ran a few minutes ago
+	rc.HSet("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup", time.Now().Add(-5*time.Minute).Unix()).Result()
+
+	//enqueues missed ticks once, even when several processes poll
+	first := newPeriodic()
+	second := newPeriodic()
+	assert.NoError(t, first.poll())
+	assert.NoError(t, second.poll())
+
+	count, _ := rc.LLen("prod:queue:maintenance").Result()
+	assert.Equal(t, int64(1), count)
+
+	job, _ := rc.LPop("prod:queue:maintenance").Result()
+	var data EnqueueData
+	json.Unmarshal([]byte(job), &data)
+	assert.Equal(t, "Cleanup", data.Class)
+	assert.True(t, data.Retry)
+
+	//doesn't enqueue again until the next tick
+	assert.NoError(t, first.poll())
+	count, _ = rc.LLen("prod:queue:maintenance").Result()
+	assert.Equal(t, int64(0), count)
+
+	//skips disabled jobs
+	rc.HSet("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup", time.Now().Add(-5*time.Minute).Unix()).Result()
+	DisablePeriodicJob("cleanup")
+
+	assert.NoError(t, first.poll())
+	count, _ = rc.LLen("prod:queue:maintenance").Result()
+	assert.Equal(t, int64(0), count)
+}
+
+func TestPeriodicRetriesFailedEnqueues(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	RegisterPeriodicJob(PeriodicJob{
+		Name:  "cleanup",
+		Spec:  "* * * * *",
+		Queue: "maintenance",
+		Class: "Cleanup",
+	})
+
+	lastRun := time.Now().Add(-5 * time.Minute).Unix()
+	rc.HSet("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup", lastRun).Result()
+
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			return errors.New("rejected")
+		}
+	})
+
+	//gives the tick back when the job can't be enqueued
+	periodic := newPeriodic()
+	assert.Error(t, periodic.poll())
+
+	stored, _ := rc.HGet("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup").Int64()
+	assert.Equal(t, lastRun, stored)
+
+	//enqueues it on the next poll
+	clientMiddlewares = nil
+	assert.NoError(t, periodic.poll())
+
+	count, _ := rc.LLen("prod:queue:maintenance").Result()
+	assert.Equal(t, int64(1), count)
+
+	//forgets the last run if there wasn't one before
+	rc.HDel("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup").Result()
+	claimTickScript.Run(rc, []string{"prod:" + PERIODIC_LAST_RUN_KEY}, "cleanup", 100)
+	releaseTickScript.Run(rc, []string{"prod:" + PERIODIC_LAST_RUN_KEY}, "cleanup", 100, 0)
+
+	found, _ := rc.HExists("prod:"+PERIODIC_LAST_RUN_KEY, "cleanup").Result()
+	assert.False(t, found)
+}
@@ -0,0 +1,93 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+// QuarantinedMsg is a payload that could not be decoded into a Msg. It is
+// kept verbatim so it can be inspected and, if needed, repaired by hand.
+type QuarantinedMsg struct {
+	Queue         string  `json:"queue"`
+	Payload       string  `json:"payload"`
+	Reason        string  `json:"reason"`
+	QuarantinedAt float64 `json:"quarantined_at"`
+
+	raw string
+}
+
+// quarantine moves a payload out of an in-progress list, if one is given,
+// and into the quarantine set, so it's no longer re-read on every restart.
+func quarantine(queue, inprogress, payload string, reason error) error {
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+	bytes, err := json.Marshal(QuarantinedMsg{
+		Queue:         Config.queueName(queue),
+		Payload:       payload,
+		Reason:        fmt.Sprintf("%v", reason),
+		QuarantinedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe := rc.TxPipeline()
+	pipe.ZAdd(Config.Namespace+QUARANTINE_KEY, redis.Z{Score: now, Member: bytes})
+	if inprogress != "" {
+		pipe.LRem(inprogress, -1, payload)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// QuarantinedMessages returns quarantined payloads, oldest first, between the
+// start and stop indexes (inclusive, as with ZRANGE).
+func QuarantinedMessages(start, stop int64) ([]*QuarantinedMsg, error) {
+	rc := Config.Client
+
+	members, err := rc.ZRange(Config.Namespace+QUARANTINE_KEY, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*QuarantinedMsg, 0, len(members))
+	for _, member := range members {
+		message := &QuarantinedMsg{raw: member}
+		if err := json.Unmarshal([]byte(member), message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// QuarantineSize returns the number of quarantined payloads.
+func QuarantineSize() (int64, error) {
+	return Config.Client.ZCard(Config.Namespace + QUARANTINE_KEY).Result()
+}
+
+// DeleteQuarantinedMessage permanently removes a quarantined payload
+// previously returned by QuarantinedMessages.
+func DeleteQuarantinedMessage(message *QuarantinedMsg) error {
+	rc := Config.Client
+
+	removed, err := rc.ZRem(Config.Namespace+QUARANTINE_KEY, message.raw).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return errors.New("quarantined message not found")
+	}
+
+	return nil
+}
+
+// ClearQuarantine removes every quarantined payload.
+func ClearQuarantine() error {
+	return Config.Client.Del(Config.Namespace + QUARANTINE_KEY).Err()
+}
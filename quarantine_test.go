@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	rc.LPush("prod:queue:myqueue:1:inprogress", "not json").Result()
+	rc.LPush("prod:queue:myqueue:1:inprogress", "{\"jid\":").Result()
+
+	err := quarantine("prod:queue:myqueue", "prod:queue:myqueue:1:inprogress", "not json", errors.New("invalid character"))
+	assert.NoError(t, err)
+	err = quarantine("prod:queue:myqueue", "prod:queue:myqueue:1:inprogress", "{\"jid\":", errors.New("unexpected EOF"))
+	assert.NoError(t, err)
+
+	//removes payloads from the in-progress list
+	inprogress, _ := rc.LLen("prod:queue:myqueue:1:inprogress").Result()
+	assert.Equal(t, int64(0), inprogress)
+
+	size, err := QuarantineSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	//lists payloads oldest first with reason and timestamp
+	messages, err := QuarantinedMessages(0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "myqueue", messages[0].Queue)
+	assert.Equal(t, "not json", messages[0].Payload)
+	assert.Equal(t, "invalid character", messages[0].Reason)
+	assert.InDelta(t, nowToSecondsWithNanoPrecision(), messages[0].QuarantinedAt, 1)
+
+	//deletes a single payload
+	assert.NoError(t, DeleteQuarantinedMessage(messages[0]))
+	assert.Error(t, DeleteQuarantinedMessage(messages[0]))
+
+	messages, _ = QuarantinedMessages(0, -1)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "{\"jid\":", messages[0].Payload)
+
+	//clears everything
+	assert.NoError(t, ClearQuarantine())
+	size, _ = QuarantineSize()
+	assert.Equal(t, int64(0), size)
+}
@@ -0,0 +1,272 @@
+package workers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// This is a variable for testing reasons
+var queuePageSize int64 = 100
+
+// Queue gives access to the jobs waiting in a queue.
+type Queue struct {
+	name string
+}
+
+// NewQueue returns the queue with the given name, without the namespace.
+func NewQueue(name string) *Queue {
+	return &Queue{name}
+}
+
+// Queues returns every known queue, sorted by name.
+func Queues() ([]*Queue, error) {
+	names, err := Config.Client.SMembers(Config.Namespace + "queues").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	queues := make([]*Queue, len(names))
+	for i, name := range names {
+		queues[i] = NewQueue(name)
+	}
+
+	return queues, nil
+}
+
+func (q *Queue) Name() string {
+	return q.name
+}
+
+func (q *Queue) redisKey() string {
+	return Config.queueKey(q.name)
+}
+
+// Size returns the number of jobs waiting in the queue.
+func (q *Queue) Size() (int64, error) {
+	return Config.Client.LLen(q.redisKey()).Result()
+}
+
+// Page returns up to count jobs starting at offset, in the order they'll
+// be processed.
+func (q *Queue) Page(offset, count int64) ([]*Msg, error) {
+	if count <= 0 {
+		return []*Msg{}, nil
+	}
+
+	// Jobs are pushed on the left and popped from the right, so the next
+	// job to run is the last element of the list.
+	jobs, err := Config.Client.LRange(q.redisKey(), -(offset + count), -(offset + 1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Msg, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		message, err := NewMsg(jobs[i])
+		if err != nil {
+			Logger.Println("ERR: Couldn't decode job in queue", q.name, ":", err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// where returns every job matching fn, walking the queue a page at a time.
+func (q *Queue) where(fn func(*Msg) bool) ([]*Msg, error) {
+	found := []*Msg{}
+
+	for offset := int64(0); ; offset += queuePageSize {
+		messages, err := q.Page(offset, queuePageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			if fn(message) {
+				found = append(found, message)
+			}
+		}
+
+		if int64(len(messages)) < queuePageSize {
+			return found, nil
+		}
+	}
+}
+
+// FindJob returns the job with the given JID, or ErrJobNotFound.
+func (q *Queue) FindJob(jid string) (*Msg, error) {
+	found, err := q.where(func(message *Msg) bool {
+		return message.Jid() == jid
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	return found[0], nil
+}
+
+// Delete removes a job from the queue.
+func (q *Queue) Delete(message *Msg) error {
+	removed, err := Config.Client.LRem(q.redisKey(), 1, message.OriginalJson()).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// DeleteWhere removes every job matching fn, returning how many were
+// removed.
+func (q *Queue) DeleteWhere(fn func(*Msg) bool) (int, error) {
+	found, err := q.where(fn)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, message := range found {
+		if err := q.Delete(message); err == nil {
+			deleted++
+		} else if err != ErrJobNotFound {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// moveScript takes the job ARGV[1] off the queue KEYS[1] and pushes it onto
+// the queue KEYS[3] as ARGV[2], registering the queue ARGV[3] in the set
+// KEYS[2]. Redis doesn't undo a script that fails partway, so a job that
+// can't be pushed is put back at the front of its queue.
+var moveScript = redis.NewScript(`
+if redis.call('lrem', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+local pushed = redis.pcall('lpush', KEYS[3], ARGV[2])
+if type(pushed) == 'table' and pushed.err then
+	redis.call('rpush', KEYS[1], ARGV[1])
+	return pushed
+end
+redis.call('sadd', KEYS[2], ARGV[3])
+return 1
+`)
+
+// Move takes a job off this queue and pushes it onto another.
+func (q *Queue) Move(message *Msg, to string) error {
+	if Config.cluster {
+		return q.moveInCluster(message, to)
+	}
+
+	message.Set("queue", to)
+
+	moved, err := moveScript.Run(Config.Client,
+		[]string{q.redisKey(), Config.Namespace + "queues", Config.queueKey(to)},
+		message.OriginalJson(), message.ToJson(), to,
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// moveInCluster is Move for redis cluster, where a script can't touch both
+// queues. The job is put back at the front of its queue if it can't be
+// pushed.
+func (q *Queue) moveInCluster(message *Msg, to string) error {
+	if err := q.Delete(message); err != nil {
+		return err
+	}
+
+	rc := Config.Client
+
+	message.Set("queue", to)
+	if _, err := rc.LPush(Config.queueKey(to), message.ToJson()).Result(); err != nil {
+		if _, pushErr := rc.RPush(q.redisKey(), message.OriginalJson()).Result(); pushErr != nil {
+			Logger.Println("ERR: Couldn't put back job", message.OriginalJson(), "in queue", q.name, ":", pushErr)
+		}
+		return err
+	}
+
+	_, err := rc.SAdd(Config.Namespace+"queues", to).Result()
+	return err
+}
+
+// MoveWhere moves every job matching fn onto another queue, returning how
+// many were moved.
+func (q *Queue) MoveWhere(fn func(*Msg) bool, to string) (int, error) {
+	found, err := q.where(fn)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, message := range found {
+		if err := q.Move(message, to); err == nil {
+			moved++
+		} else if err != ErrJobNotFound {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// Pause stops every process from fetching jobs from the queue until it's
+// resumed. Jobs can still be enqueued while the queue is paused.
+func (q *Queue) Pause() error {
+	return Config.Client.SAdd(Config.Namespace+PAUSED_QUEUES_KEY, q.name).Err()
+}
+
+// Resume lets processes fetch jobs from a paused queue again.
+func (q *Queue) Resume() error {
+	return Config.Client.SRem(Config.Namespace+PAUSED_QUEUES_KEY, q.name).Err()
+}
+
+// Paused reports whether the queue is paused.
+func (q *Queue) Paused() (bool, error) {
+	return Config.Client.SIsMember(Config.Namespace+PAUSED_QUEUES_KEY, q.name).Result()
+}
+
+// Clear removes every job from the queue and forgets the queue.
+func (q *Queue) Clear() error {
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	pipe.Del(q.redisKey())
+	pipe.SRem(Config.Namespace+"queues", q.name)
+	_, err := pipe.Exec()
+	return err
+}
+
+// Latency returns how long the next job to be processed has been waiting,
+// or zero if the queue is empty.
+func (q *Queue) Latency() (time.Duration, error) {
+	messages, err := q.Page(0, 1)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	enqueuedAt, err := messages[0].Get("enqueued_at").Float64()
+	if err != nil {
+		return 0, nil
+	}
+
+	return time.Since(secondsToTime(enqueuedAt)), nil
+}
@@ -0,0 +1,179 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueues(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	Enqueue("zebra", "Add", []int{1})
+	Enqueue("alpha", "Add", []int{1})
+
+	queues, err := Queues()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(queues))
+	assert.Equal(t, "alpha", queues[0].Name())
+	assert.Equal(t, "zebra", queues[1].Name())
+}
+
+func TestQueue(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	oldPageSize := queuePageSize
+	defer func() {
+		queuePageSize = oldPageSize
+	}()
+	queuePageSize = 2
+
+	var jids []string
+	for i := 0; i < 5; i++ {
+		class := "Add"
+		if i%2 == 1 {
+			class = "Subtract"
+		}
+		jid, _ := Enqueue("default", class, []int{i})
+		jids = append(jids, jid)
+	}
+
+	queue := NewQueue("default")
+
+	size, err := queue.Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	//pages through jobs in processing order
+	page, err := queue.Page(0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Equal(t, jids[0], page[0].Jid())
+	assert.Equal(t, jids[1], page[1].Jid())
+
+	page, _ = queue.Page(4, 2)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, jids[4], page[0].Jid())
+
+	//finds jobs by jid
+	message, err := queue.FindJob(jids[3])
+	assert.NoError(t, err)
+	assert.Equal(t, jids[3], message.Jid())
+
+	_, err = queue.FindJob("missing")
+	assert.Equal(t, ErrJobNotFound, err)
+
+	//computes latency from the oldest job
+	latency, err := queue.Latency()
+	assert.NoError(t, err)
+	assert.True(t, latency > 0 && latency < time.Second)
+
+	//moves jobs between queues
+	assert.NoError(t, queue.Move(message, "other"))
+	assert.Equal(t, ErrJobNotFound, queue.Move(message, "other"))
+
+	moved, err := NewQueue("other").FindJob(jids[3])
+	assert.NoError(t, err)
+	queueName, _ := moved.Get("queue").String()
+	assert.Equal(t, "other", queueName)
+
+	//deletes matching jobs
+	deleted, err := queue.DeleteWhere(func(message *Msg) bool {
+		class, _ := message.Get("class").String()
+		return class == "Subtract"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	count, err := queue.MoveWhere(func(message *Msg) bool {
+		return message.Jid() == jids[4]
+	}, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	size, _ = queue.Size()
+	assert.Equal(t, int64(2), size)
+
+	//clears the queue
+	assert.NoError(t, queue.Clear())
+	size, _ = queue.Size()
+	assert.Equal(t, int64(0), size)
+
+	latency, err = queue.Latency()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), latency)
+
+	queues, _ := Queues()
+	assert.Equal(t, 1, len(queues))
+	assert.Equal(t, "other", queues[0].Name())
+}
+
+func TestPauseQueue(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	queue := NewQueue("default")
+
+	paused, err := queue.Paused()
+	assert.NoError(t, err)
+	assert.False(t, paused)
+
+	assert.NoError(t, queue.Pause())
+	paused, _ = queue.Paused()
+	assert.True(t, paused)
+
+	assert.NoError(t, queue.Resume())
+	paused, _ = queue.Paused()
+	assert.False(t, paused)
+}
+
+func TestQueuePageWithoutCount(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	Enqueue("default", "Add", []int{1})
+	Enqueue("default", "Add", []int{2})
+
+	queue := NewQueue("default")
+
+	//returns no jobs rather than the whole queue
+	page, err := queue.Page(0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+
+	page, err = queue.Page(1, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(page))
+}
+
+func TestMoveKeepsJobsItCantPush(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	jid, _ := Enqueue("default", "Report", []int{1})
+
+	//a queue key that isn't a list makes the push fail
+	rc.Set("prod:queue:broken", "not a list", 0).Result()
+
+	queue := NewQueue("default")
+	message, _ := queue.FindJob(jid)
+	assert.Error(t, queue.Move(message, "broken"))
+
+	message, err := queue.FindJob(jid)
+	assert.NoError(t, err)
+	queueName, _ := message.Get("queue").String()
+	assert.Equal(t, "default", queueName)
+
+	//in cluster mode too
+	Config.cluster = true
+	defer func() {
+		Config.cluster = false
+	}()
+	Enqueue("clustered", "Report", []int{2})
+	rc.Set("prod:queue:{broken}", "not a list", 0).Result()
+
+	queue = NewQueue("clustered")
+	page, _ := queue.Page(0, 1)
+	assert.Error(t, queue.Move(page[0], "broken"))
+
+	size, _ := queue.Size()
+	assert.Equal(t, int64(1), size)
+}
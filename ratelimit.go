@@ -0,0 +1,299 @@
+package workers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// These are variables for testing reasons
+var concurrentLimitWait = 5 * time.Second
+var concurrencyLease = 30 * time.Second
+
+// RateLimiter limits jobs sharing a key across every process.
+type RateLimiter interface {
+	// Acquire takes a slot for key. If there's none, it returns how long to
+	// wait before trying again. Otherwise, release is called once the job
+	// has run.
+	Acquire(key string) (release func(), wait time.Duration, err error)
+}
+
+// LimitKeyFunc returns the key a job is limited by. Jobs with the same key
+// share a limit.
+type LimitKeyFunc func(queue string, message *Msg) string
+
+// LimitByQueue limits the jobs of each queue together.
+func LimitByQueue(queue string, message *Msg) string {
+	return strings.TrimPrefix(queue, Config.Namespace)
+}
+
+// LimitByClass limits the jobs of each class together.
+func LimitByClass(queue string, message *Msg) string {
+	class, _ := message.Get("class").String()
+	return class
+}
+
+// LimitByArg limits jobs with the same argument at index together, such as
+// an account ID.
+func LimitByArg(index int) LimitKeyFunc {
+	return func(queue string, message *Msg) string {
+		arg, _ := message.Args().GetIndex(index).Encode()
+		return string(arg)
+	}
+}
+
+// RateLimit reschedules jobs when limiter has no slot for their key. They
+// run again once the limiter says so, without counting as failures or
+// using up retries.
+//
+// Prepend it to the other middlewares, so they don't see rescheduled jobs:
+//
+//	workers.Process("api", job, 10, workers.DefaultMiddlewares().Prepend(
+//		workers.RateLimit(workers.NewTokenBucket("api", 100, time.Minute, 10), workers.LimitByQueue),
+//	)...)
+func RateLimit(limiter RateLimiter, key LimitKeyFunc) MiddlewareFunc {
+	return func(queue string, next JobFunc) JobFunc {
+		return func(message *Msg) error {
+			release, wait, err := limiter.Acquire(key(queue, message))
+			if err != nil {
+				// Keep the job in progress rather than lose it
+				message.ack = false
+				return err
+			}
+
+			if wait > 0 {
+				if err := reschedule(queue, message, wait); err != nil {
+					message.ack = false
+					return err
+				}
+				return nil
+			}
+
+			defer release()
+			return next(message)
+		}
+	}
+}
+
+// reschedule puts a job back in the scheduled set to run after wait,
+// keeping its retry count.
+func reschedule(queue string, message *Msg, wait time.Duration) error {
+	message.Set("queue", queue)
+
+	if message.tracked() {
+		updateStatus(message.Jid(), map[string]interface{}{"status": StatusScheduled})
+	}
+
+	at := nowToSecondsWithNanoPrecision() + durationToSecondsWithNanoPrecision(wait)
+	return enqueueAt(at, []byte(message.ToJson()))
+}
+
+type tokenBucket struct {
+	name  string
+	rate  float64
+	burst int
+}
+
+// NewTokenBucket allows limit jobs every per, in bursts of up to burst jobs.
+func NewTokenBucket(name string, limit int, per time.Duration, burst int) RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{name, float64(limit) / per.Seconds(), burst}
+}
+
+// tokenBucketScript refills the bucket KEYS[1] at ARGV[1] tokens a second
+// up to ARGV[2] tokens, and takes one. Returns how many seconds until
+// there's a token, or 0 if it took one.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(redis.call('hget', KEYS[1], 'tokens')) or burst
+local at = tonumber(redis.call('hget', KEYS[1], 'at')) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = (1 - tokens) / rate
+end
+redis.call('hmset', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('expire', KEYS[1], ARGV[4])
+return tostring(wait)
+`)
+
+func (b *tokenBucket) Acquire(key string) (func(), time.Duration, error) {
+	ttl := int64(math.Ceil(float64(b.burst)/b.rate)) + 1
+
+	wait, err := tokenBucketScript.Run(Config.Client,
+		[]string{rateLimitKey(b.name, key)},
+		b.rate, b.burst, nowToSecondsWithNanoPrecision(), ttl,
+	).String()
+
+	return limiterResult(wait, err, func() {})
+}
+
+type slidingWindow struct {
+	name   string
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindow allows limit jobs in any window of time.
+func NewSlidingWindow(name string, limit int, window time.Duration) RateLimiter {
+	return &slidingWindow{name, limit, window}
+}
+
+// slidingWindowScript drops the entries of KEYS[1] older than ARGV[2]
+// seconds, and adds ARGV[4] if there are fewer than ARGV[3] left. Returns
+// how many seconds until the oldest entry drops, or 0 if it added one.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call('zremrangebyscore', KEYS[1], '-inf', now - window)
+if redis.call('zcard', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('zadd', KEYS[1], now, ARGV[4])
+	redis.call('expire', KEYS[1], math.ceil(window))
+	return '0'
+end
+local oldest = redis.call('zrange', KEYS[1], 0, 0, 'withscores')
+return tostring(tonumber(oldest[2]) + window - now)
+`)
+
+func (w *slidingWindow) Acquire(key string) (func(), time.Duration, error) {
+	wait, err := slidingWindowScript.Run(Config.Client,
+		[]string{rateLimitKey(w.name, key)},
+		nowToSecondsWithNanoPrecision(), w.window.Seconds(), w.limit, generateJid(),
+	).String()
+
+	return limiterResult(wait, err, func() {})
+}
+
+type concurrentLimit struct {
+	name  string
+	limit int
+	lease time.Duration
+}
+
+// minConcurrencyLease keeps renewals, every third of a lease, from
+// flooding redis.
+const minConcurrencyLease = 10 * time.Millisecond
+
+// NewConcurrentLimit allows limit jobs to run at once. Slots are leased
+// for lease and renewed while jobs run, so the slots of processes that die
+// are freed once their lease ends. It panics if lease is shorter than 10ms.
+func NewConcurrentLimit(name string, limit int, lease time.Duration) RateLimiter {
+	if lease < minConcurrencyLease {
+		panic(fmt.Sprintf("workers: concurrent limit %s has a lease of %v, shorter than %v", name, lease, minConcurrencyLease))
+	}
+	return &concurrentLimit{name, limit, lease}
+}
+
+// concurrentLimitScript drops the leases in KEYS[1] that have ended by
+// ARGV[1], and leases ARGV[4] until ARGV[1]+ARGV[2] if there are fewer than
+// ARGV[3]. Returns ARGV[5], the seconds to wait, or 0 if it added one.
+var concurrentLimitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+redis.call('zremrangebyscore', KEYS[1], '-inf', now)
+if redis.call('zcard', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('zadd', KEYS[1], now + lease, ARGV[4])
+	redis.call('expire', KEYS[1], math.ceil(lease))
+	return '0'
+end
+return ARGV[5]
+`)
+
+// renewLeaseScript extends the lease ARGV[1] in KEYS[1] until ARGV[2],
+// unless it has already ended. Returns 1 if it was renewed.
+var renewLeaseScript = redis.NewScript(`
+if not redis.call('zscore', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('zadd', KEYS[1], ARGV[2], ARGV[1])
+redis.call('expire', KEYS[1], ARGV[3])
+return 1
+`)
+
+func (c *concurrentLimit) Acquire(key string) (func(), time.Duration, error) {
+	token := generateJid()
+	redisKey := rateLimitKey(c.name, key)
+
+	wait, err := concurrentLimitScript.Run(Config.Client,
+		[]string{redisKey},
+		nowToSecondsWithNanoPrecision(), c.lease.Seconds(), c.limit, token, concurrentLimitWait.Seconds(),
+	).String()
+
+	release, delay, err := limiterResult(wait, err, func() {})
+	if release == nil {
+		return nil, delay, err
+	}
+
+	stop := make(chan bool)
+	go c.renew(redisKey, token, stop)
+
+	return func() {
+		close(stop)
+		if err := Config.Client.ZRem(redisKey, token).Err(); err != nil {
+			Logger.Println("ERR: Couldn't release", c.name, "slot for", key, ":", err)
+		}
+	}, 0, nil
+}
+
+// renew extends a lease until stop is closed, so jobs keep their slot
+// however long they run.
+func (c *concurrentLimit) renew(redisKey, token string, stop chan bool) {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+
+	ttl := int64(math.Ceil(c.lease.Seconds()))
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			at := nowToSecondsWithNanoPrecision() + c.lease.Seconds()
+			renewed, err := renewLeaseScript.Run(Config.Client, []string{redisKey}, token, at, ttl).Int64()
+			if err != nil {
+				Logger.Println("ERR: Couldn't renew", c.name, "lease", token, ":", err)
+			} else if renewed == 0 {
+				Logger.Println("ERR: Lost", c.name, "lease", token)
+				return
+			}
+		}
+	}
+}
+
+// ConcurrencyLimit allows at most limit jobs with the same key to run at
+// once across every process, such as 3 jobs per tenant. Jobs that can't
+// get a slot are rescheduled to try again after a few seconds, and don't
+// use up retries. Like RateLimit, prepend it to the other middlewares.
+func ConcurrencyLimit(name string, limit int, key LimitKeyFunc) MiddlewareFunc {
+	return RateLimit(NewConcurrentLimit(name, limit, concurrencyLease), key)
+}
+
+func limiterResult(wait string, err error, release func()) (func(), time.Duration, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	if seconds > 0 {
+		return nil, time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return release, 0, nil
+}
+
+func rateLimitKey(name, key string) string {
+	return fmt.Sprintf("%s%s:%s:%s", Config.Namespace, RATE_LIMIT_KEY, name, key)
+}
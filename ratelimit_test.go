@@ -0,0 +1,195 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	limiter := NewTokenBucket("api", 1, time.Hour, 2)
+
+	//allows bursts
+	for i := 0; i < 2; i++ {
+		release, wait, err := limiter.Acquire("a")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+		release()
+	}
+
+	//waits for the next token
+	_, wait, err := limiter.Acquire("a")
+	assert.NoError(t, err)
+	assert.True(t, wait > 59*time.Minute && wait <= time.Hour)
+
+	//limits keys separately
+	_, wait, _ = limiter.Acquire("b")
+	assert.Equal(t, time.Duration(0), wait)
+
+	ttl, _ := Config.Client.TTL("prod:limit:api:a").Result()
+	assert.True(t, ttl > 0)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	limiter := NewSlidingWindow("api", 2, 100*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		_, wait, err := limiter.Acquire("a")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	//waits for the oldest job to leave the window
+	_, wait, err := limiter.Acquire("a")
+	assert.NoError(t, err)
+	assert.True(t, wait > 0 && wait <= 100*time.Millisecond)
+
+	time.Sleep(wait + 10*time.Millisecond)
+	_, wait, _ = limiter.Acquire("a")
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestConcurrentLimit(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	limiter := NewConcurrentLimit("api", 1, 100*time.Millisecond)
+
+	release, wait, err := limiter.Acquire("a")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	_, wait, _ = limiter.Acquire("a")
+	assert.Equal(t, concurrentLimitWait, wait)
+
+	//frees slots once jobs are done
+	release()
+	release, wait, _ = limiter.Acquire("a")
+	assert.Equal(t, time.Duration(0), wait)
+	release()
+
+	//frees the slots of dead processes once their lease ends
+	Config.Client.ZAdd("prod:limit:api:b", redis.Z{Score: nowToSecondsWithNanoPrecision() - 1, Member: "dead"})
+	release, wait, _ = limiter.Acquire("b")
+	assert.Equal(t, time.Duration(0), wait)
+	release()
+
+	//rejects leases too short to renew
+	assert.Panics(t, func() { NewConcurrentLimit("api", 1, 0) })
+	assert.Panics(t, func() { NewConcurrentLimit("api", 1, -time.Second) })
+	assert.Panics(t, func() { NewConcurrentLimit("api", 1, time.Nanosecond) })
+	assert.NotPanics(t, func() { NewConcurrentLimit("api", 1, 10*time.Millisecond) })
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	runs := 0
+	job := RateLimit(NewTokenBucket("api", 1, time.Hour, 1), LimitByArg(0))("prod:myqueue", func(message *Msg) error {
+		runs++
+		return nil
+	})
+
+	message, _ := NewMsg("{\"jid\":\"1\",\"class\":\"Call\",\"args\":[\"acme\"],\"retry\":true,\"retry_count\":2}")
+	assert.NoError(t, job(message))
+	assert.Equal(t, 1, runs)
+
+	//reschedules jobs over the limit instead of failing them
+	message, _ = NewMsg("{\"jid\":\"2\",\"class\":\"Call\",\"args\":[\"acme\"],\"retry\":true,\"retry_count\":2}")
+	assert.NoError(t, job(message))
+	assert.Equal(t, 1, runs)
+	assert.True(t, message.ack)
+
+	scheduled, _ := ScheduledSet().Page(0, -1)
+	assert.Equal(t, 1, len(scheduled))
+	assert.Equal(t, "2", scheduled[0].Jid())
+	assert.True(t, scheduled[0].At.After(time.Now().Add(59*time.Minute)))
+
+	//keeps the retry count
+	count, _ := scheduled[0].Get("retry_count").Int()
+	assert.Equal(t, 2, count)
+
+	retries, _ := RetrySet().Size()
+	assert.Equal(t, int64(0), retries)
+
+	//limits other keys separately
+	message, _ = NewMsg("{\"jid\":\"3\",\"class\":\"Call\",\"args\":[\"other\"]}")
+	assert.NoError(t, job(message))
+	assert.Equal(t, 2, runs)
+}
+
+func TestConcurrentLimitRenewsLeases(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	limiter := NewConcurrentLimit("api", 1, 60*time.Millisecond)
+
+	release, _, _ := limiter.Acquire("a")
+
+	//keeps the slot while the job runs past its lease
+	time.Sleep(150 * time.Millisecond)
+	_, wait, _ := limiter.Acquire("a")
+	assert.Equal(t, concurrentLimitWait, wait)
+
+	release()
+	release, wait, _ = limiter.Acquire("a")
+	assert.Equal(t, time.Duration(0), wait)
+	release()
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	tenant := func(queue string, message *Msg) string {
+		tenant, _ := message.Get("tenant").String()
+		return tenant
+	}
+
+	started := make(chan bool)
+	finish := make(chan bool)
+	job := ConcurrencyLimit("tenants", 1, tenant)("prod:myqueue", func(message *Msg) error {
+		started <- true
+		<-finish
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		message, _ := NewMsg("{\"jid\":\"1\",\"tenant\":\"acme\"}")
+		done <- job(message)
+	}()
+	<-started
+
+	//requeues jobs for a tenant that's at its limit
+	message, _ := NewMsg("{\"jid\":\"2\",\"tenant\":\"acme\"}")
+	assert.NoError(t, job(message))
+
+	scheduled, _ := ScheduledSet().Page(0, -1)
+	assert.Equal(t, 1, len(scheduled))
+	assert.Equal(t, "2", scheduled[0].Jid())
+
+	//runs jobs for other tenants
+	go func() {
+		message, _ := NewMsg("{\"jid\":\"3\",\"tenant\":\"other\"}")
+		done <- job(message)
+	}()
+	<-started
+
+	finish <- true
+	finish <- true
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+
+	//frees the slot once the job is done
+	go func() {
+		message, _ := NewMsg("{\"jid\":\"4\",\"tenant\":\"acme\"}")
+		done <- job(message)
+	}()
+	<-started
+	finish <- true
+	assert.NoError(t, <-done)
+}
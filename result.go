@@ -0,0 +1,181 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// This is a variable for testing reasons
+var resultTTL = 24 * time.Hour
+
+// ResultJobFunc is a job that returns a value, see WithResult.
+type ResultJobFunc func(message *Msg) (interface{}, error)
+
+// WithResult turns a job returning a value into a JobFunc. For jobs
+// enqueued with StoreResult, the value is encoded as JSON and kept for
+// Await and Result.
+func WithResult(job ResultJobFunc) JobFunc {
+	return func(message *Msg) error {
+		result, err := job(message)
+		if err == nil {
+			message.result = result
+		}
+		return err
+	}
+}
+
+// JobResult is the outcome of a job enqueued with StoreResult, once it has
+// succeeded or failed for the last time.
+type JobResult struct {
+	Jid        string          `json:"jid"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Status     string          `json:"status"`
+	FinishedAt float64         `json:"finished_at"`
+}
+
+// Failed reports whether the job failed, was killed or was cancelled.
+func (r *JobResult) Failed() bool {
+	return r.Status != StatusComplete
+}
+
+// Decode unmarshals the value the job returned into v.
+func (r *JobResult) Decode(v interface{}) error {
+	if r.Failed() {
+		return errors.New("job " + r.Status + ": " + r.Error)
+	}
+	if len(r.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Value, v)
+}
+
+// Result returns the outcome of a job enqueued with StoreResult, or
+// ErrJobNotFound if it hasn't finished yet or its result has expired.
+// Results are kept for 24 hours.
+func Result(jid string) (*JobResult, error) {
+	bytes, err := Config.Client.Get(resultKey(jid)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	return decodeResult(bytes)
+}
+
+// Await blocks until a job enqueued with StoreResult has succeeded or
+// failed for the last time, or ctx is done. Retries aren't final, so
+// Await keeps waiting while a job is being retried.
+func Await(ctx context.Context, jid string) (*JobResult, error) {
+	// Subscribe before checking for a result, so one stored in between
+	// isn't missed.
+	pubsub := Config.Client.Subscribe(resultKey(jid))
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(); err != nil {
+		return nil, err
+	}
+
+	if result, err := Result(jid); err != ErrJobNotFound {
+		return result, err
+	}
+
+	select {
+	case message, ok := <-pubsub.Channel():
+		if !ok {
+			return nil, errors.New("subscription closed")
+		}
+		return decodeResult([]byte(message.Payload))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ResultMiddleware stores the outcome of jobs enqueued with StoreResult,
+// once they've succeeded or failed for the last time, and notifies anyone
+// waiting in Await. It's one of the default middlewares, and should run
+// outside RetryMiddleware.
+func ResultMiddleware(queue string, next JobFunc) JobFunc {
+	return func(message *Msg) (err error) {
+		if stored, _ := message.Get("store_result").Bool(); !stored {
+			return next(message)
+		}
+
+		before := RetrySnapshot(message)
+
+		defer func() {
+			e := recover()
+			if e != nil {
+				err = fmt.Errorf("%v", e)
+			}
+
+			result := &JobResult{
+				Jid:        message.Jid(),
+				Status:     JobOutcome(message, before, err),
+				FinishedAt: nowToSecondsWithNanoPrecision(),
+			}
+
+			switch result.Status {
+			case StatusRetrying:
+				// Not final, the next attempt stores the result
+			case StatusComplete:
+				if storeErr := storeResult(result, message.result); storeErr != nil {
+					Logger.Println("ERR: Couldn't store result of", message.Jid(), ":", storeErr)
+				}
+			default:
+				result.Error = err.Error()
+				if storeErr := storeResult(result, nil); storeErr != nil {
+					Logger.Println("ERR: Couldn't store result of", message.Jid(), ":", storeErr)
+				}
+			}
+
+			if e != nil {
+				panic(e)
+			}
+		}()
+
+		return next(message)
+	}
+}
+
+func storeResult(result *JobResult, value interface{}) error {
+	if value != nil {
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		result.Value = bytes
+	}
+
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	rc := Config.Client
+
+	key := resultKey(result.Jid)
+	if err := rc.Set(key, bytes, resultTTL).Err(); err != nil {
+		return err
+	}
+	return rc.Publish(key, string(bytes)).Err()
+}
+
+func decodeResult(bytes []byte) (*JobResult, error) {
+	result := &JobResult{}
+	if err := json.Unmarshal(bytes, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func resultKey(jid string) string {
+	return Config.Namespace + RESULT_KEY + ":" + jid
+}
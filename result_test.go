@@ -0,0 +1,100 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultMiddleware(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	mids := NewMiddlewares(ResultMiddleware, RetryMiddleware)
+
+	//stores the value returned by successful jobs
+	message, _ := NewMsg("{\"jid\":\"1\",\"store_result\":true}")
+	err := mids.build("myqueue", WithResult(func(message *Msg) (interface{}, error) {
+		return map[string]int{"sum": 3}, nil
+	}))(message)
+	assert.NoError(t, err)
+
+	result, err := Result("1")
+	assert.NoError(t, err)
+	assert.False(t, result.Failed())
+
+	var value map[string]int
+	assert.NoError(t, result.Decode(&value))
+	assert.Equal(t, 3, value["sum"])
+
+	ttl, _ := rc.TTL("prod:result:1").Result()
+	assert.True(t, ttl > 0)
+
+	failing := mids.build("myqueue", WithResult(func(message *Msg) (interface{}, error) {
+		return nil, errors.New("AHHHH")
+	}))
+
+	//waits for retries to finish
+	message, _ = NewMsg("{\"jid\":\"2\",\"store_result\":true,\"retry\":true}")
+	failing(message)
+	_, err = Result("2")
+	assert.Equal(t, ErrJobNotFound, err)
+
+	//stores final failures
+	message, _ = NewMsg("{\"jid\":\"3\",\"store_result\":true}")
+	failing(message)
+	result, err = Result("3")
+	assert.NoError(t, err)
+	assert.True(t, result.Failed())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, "AHHHH", result.Error)
+	assert.Error(t, result.Decode(&value))
+
+	//doesn't store results unless asked to
+	message, _ = NewMsg("{\"jid\":\"4\"}")
+	failing(message)
+	_, err = Result("4")
+	assert.Equal(t, ErrJobNotFound, err)
+}
+
+func TestAwait(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	Process("awaitQueue", WithResult(func(message *Msg) (interface{}, error) {
+		a, _ := message.Args().GetIndex(0).Int()
+		b, _ := message.Args().GetIndex(1).Int()
+		return a + b, nil
+	}), 1)
+	defer forgetManager("awaitQueue")
+
+	Start()
+	defer Quit()
+
+	//blocks until the job is done
+	jid, _ := EnqueueWithOptions("awaitQueue", "Add", []int{1, 2}, EnqueueOptions{StoreResult: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := Await(ctx, jid)
+	assert.NoError(t, err)
+
+	var sum int
+	assert.NoError(t, result.Decode(&sum))
+	assert.Equal(t, 3, sum)
+
+	//returns results that are already stored
+	result, err = Await(ctx, jid)
+	assert.NoError(t, err)
+	assert.Equal(t, jid, result.Jid)
+
+	//gives up when the context is done
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelTimeout()
+
+	_, err = Await(timeout, "missing")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
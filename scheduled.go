@@ -1,6 +1,8 @@
 package workers
 
 import (
+	"errors"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -9,9 +11,12 @@ import (
 )
 
 type scheduled struct {
-	keys   []string
-	closed chan bool
-	exit   chan bool
+	keys     []string
+	identity string
+	backoff  *backoff
+	errors   *logLimiter
+	closed   chan bool
+	exit     chan bool
 }
 
 func (s *scheduled) start() {
@@ -23,48 +28,322 @@ func (s *scheduled) start() {
 			default:
 			}
 
-			s.poll()
+			leader, err := s.lead()
+			if err == nil && leader {
+				err = s.poll()
+			}
+
+			if err != nil {
+				broker.failed(err)
+				s.errors.Println("ERR: couldn't poll scheduled jobs:", err)
+				time.Sleep(s.backoff.next())
+				continue
+			}
 
-			time.Sleep(time.Duration(Config.PollInterval) * time.Second)
+			broker.succeeded()
+			s.backoff.reset()
+
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(s.pollInterval()):
+			}
 		}
 	})()
 }
 
 func (s *scheduled) quit() {
 	close(s.closed)
+
+	if Config.SchedulerLeaderElection {
+		rc := Config.Client
+		releaseLeaderScript.Run(rc, []string{Config.Namespace + SCHEDULER_LEADER_KEY}, s.identity)
+	}
 }
 
-func (s *scheduled) poll() {
+// acquireLeaderScript takes the lock KEYS[1] for the identity ARGV[1] for
+// ARGV[2] milliseconds, or extends it if that identity already holds it.
+var acquireLeaderScript = redis.NewScript(`
+if redis.call('get', KEYS[1]) == ARGV[1] then
+	redis.call('pexpire', KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call('set', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseLeaderScript deletes the lock KEYS[1] if it's held by ARGV[1].
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('get', KEYS[1]) == ARGV[1] then
+	return redis.call('del', KEYS[1])
+end
+return 0
+`)
+
+// lead reports whether this process should poll. With leader election
+// enabled, only the process holding the scheduler lock polls; the lock
+// outlives a few poll intervals so a dead leader is replaced quickly.
+func (s *scheduled) lead() (bool, error) {
+	if !Config.SchedulerLeaderElection {
+		return true, nil
+	}
+
 	rc := Config.Client
 
-	now := nowToSecondsWithNanoPrecision()
+	ttl := 3 * time.Duration(Config.PollInterval) * time.Second
+	acquired, err := acquireLeaderScript.Run(rc,
+		[]string{Config.Namespace + SCHEDULER_LEADER_KEY},
+		s.identity, int64(ttl/time.Millisecond),
+	).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return acquired == 1, nil
+}
+
+// pollInterval returns how long to wait before polling again. With
+// AdaptivePollInterval, the interval is scaled by the number of live
+// processes and randomized the way Sidekiq does, so that on average the
+// sets are polled once every PollInterval across the whole cluster.
+func (s *scheduled) pollInterval() time.Duration {
+	interval := time.Duration(Config.PollInterval) * time.Second
+	if !Config.AdaptivePollInterval || Config.SchedulerLeaderElection {
+		return interval
+	}
+
+	count, err := processCount()
+	if err != nil || count < 1 {
+		count = 1
+	}
+	interval *= time.Duration(count)
+
+	// Small clusters stay within half an interval of the average; large
+	// ones have enough processes for the full range to even out.
+	if count < 10 {
+		return interval/2 + time.Duration(rand.Int63n(int64(interval)))
+	}
+	return time.Duration(rand.Int63n(int64(2 * interval)))
+}
+
+// This is a variable for testing reasons
+var scheduledBatchSize = 100
+
+// promoteScript atomically moves up to ARGV[2] jobs due at or before ARGV[1]
+// from the sorted set KEYS[1] onto their queues, registering each queue in
+// the set KEYS[2] and updating enqueued_at. Jobs without a decodable queue
+// are removed and returned so they can be quarantined, and jobs that have
+// expired are removed and returned so they can be discarded.
+var promoteScript = redis.NewScript(`
+local now, namespace, prefix = ARGV[1], ARGV[3], ARGV[4]
+
+-- Sets the top-level enqueued_at of job in place, skipping over strings and
+-- nested values so one inside the args is left alone. Re-encoding with
+-- cjson would turn empty arrays into objects and round numbers to 14
+-- digits.
+local function set_enqueued_at(job)
+	local depth, i = 0, 1
+	while i <= #job do
+		local c = string.sub(job, i, i)
+		if c == '"' then
+			local close = i + 1
+			while true do
+				close = string.find(job, '["\\]', close)
+				if not close or string.sub(job, close, close) == '"' then
+					break
+				end
+				close = close + 2
+			end
+			if not close then
+				break
+			end
+
+			if depth == 1 and string.sub(job, i, close) == '"enqueued_at"' then
+				local _, colon = string.find(job, '^%s*:%s*', close + 1)
+				if colon then
+					for _, value in ipairs({'^[-+%d%.eE]+', '^null', '^"[^"\\]*"'}) do
+						local _, last = string.find(job, value, colon + 1)
+						if last then
+							return string.sub(job, 1, colon) .. now .. string.sub(job, last + 1)
+						end
+					end
+				end
+			end
+			i = close + 1
+		else
+			if c == '{' or c == '[' then
+				depth = depth + 1
+			elseif c == '}' or c == ']' then
+				depth = depth - 1
+			end
+			i = i + 1
+		end
+	end
+
+	if string.match(job, '^%s*{%s*}%s*$') then
+		return '{"enqueued_at":' .. now .. '}'
+	end
+	return (string.gsub(job, '^%s*{', '{"enqueued_at":' .. now .. ',', 1))
+end
+local jobs = redis.call('zrangebyscore', KEYS[1], '-inf', now, 'LIMIT', 0, tonumber(ARGV[2]))
+local rejected, expired = {}, {}
+
+for _, job in ipairs(jobs) do
+	redis.call('zrem', KEYS[1], job)
+
+	local ok, decoded = pcall(cjson.decode, job)
+	local queue = ok and type(decoded) == 'table' and decoded['queue']
+
+	local expires_at = ok and type(decoded) == 'table' and tonumber(decoded['expires_at'])
+
+	if expires_at and expires_at > 0 and expires_at < tonumber(now) then
+		table.insert(expired, job)
+	elseif type(queue) == 'string' and queue ~= '' then
+		if string.sub(queue, 1, #namespace) == namespace then
+			queue = string.sub(queue, #namespace + 1)
+		end
+
+		redis.call('sadd', KEYS[2], queue)
+		redis.call('lpush', prefix .. queue, set_enqueued_at(job))
+	else
+		table.insert(rejected, job)
+	end
+end
+
+return {#jobs, rejected, expired}
+`)
+
+// popScript atomically removes and returns up to ARGV[2] jobs due at or
+// before ARGV[1] from the sorted set KEYS[1]. It's used in cluster mode,
+// where a single script can't touch both the set and the queues.
+var popScript = redis.NewScript(`
+local jobs = redis.call('zrangebyscore', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, job in ipairs(jobs) do
+	redis.call('zrem', KEYS[1], job)
+end
+return jobs
+`)
+
+func (s *scheduled) poll() error {
+	now := strconv.FormatFloat(nowToSecondsWithNanoPrecision(), 'f', -1, 64)
 
 	for _, key := range s.keys {
 		key = Config.Namespace + key
 		for {
-			messages, _ := rc.ZRangeByScore(key, redis.ZRangeBy{
-				Min:    "-inf",
-				Max:    strconv.FormatFloat(now, 'f', -1, 64),
-				Offset: 0,
-				Count:  1,
-			}).Result()
-
-			if len(messages) == 0 {
+			var count int
+			var err error
+			if Config.cluster {
+				count, err = s.popAndPromote(key, now)
+			} else {
+				count, err = s.promote(key, now)
+			}
+			if err != nil {
+				return err
+			}
+
+			if count < scheduledBatchSize {
 				break
 			}
+		}
+	}
 
-			message, _ := NewMsg(messages[0])
+	return nil
+}
 
-			if removed, _ := rc.ZRem(key, messages[0]).Result(); removed != 0 {
-				queue, _ := message.Get("queue").String()
-				queue = strings.TrimPrefix(queue, Config.Namespace)
-				message.Set("enqueued_at", nowToSecondsWithNanoPrecision())
-				rc.LPush(Config.Namespace+"queue:"+queue, message.ToJson()).Result()
-			}
+func (s *scheduled) promote(key, now string) (int, error) {
+	rc := Config.Client
+
+	result, err := promoteScript.Run(rc,
+		[]string{key, Config.Namespace + "queues"},
+		now, scheduledBatchSize, Config.Namespace, Config.Namespace+"queue:",
+	).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	reply := result.([]interface{})
+	for _, job := range reply[1].([]interface{}) {
+		s.reject(job.(string), errors.New("job has no queue"))
+	}
+	for _, job := range reply[2].([]interface{}) {
+		if message, err := NewMsg(job.(string)); err == nil {
+			s.expire(message)
 		}
 	}
+
+	return int(reply[0].(int64)), nil
+}
+
+// popAndPromote pops due jobs atomically and pushes them one at a time. If
+// a push fails, the jobs that weren't pushed are put back in the set, due
+// now, so they're promoted on the next poll.
+func (s *scheduled) popAndPromote(key, now string) (int, error) {
+	rc := Config.Client
+
+	jobs, err := popScript.Run(rc, []string{key}, now, scheduledBatchSize).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	popped := jobs.([]interface{})
+	for i, job := range popped {
+		message, err := NewMsg(job.(string))
+		if err != nil {
+			s.reject(job.(string), err)
+			continue
+		}
+
+		if queue, _ := message.Get("queue").String(); queue == "" {
+			s.reject(job.(string), errors.New("job has no queue"))
+			continue
+		}
+
+		if message.expired() {
+			s.expire(message)
+			continue
+		}
+
+		if err := requeue(message); err != nil {
+			s.restore(key, now, popped[i:])
+			return 0, err
+		}
+	}
+
+	return len(popped), nil
+}
+
+// restore puts jobs popped by popAndPromote back in the set key.
+func (s *scheduled) restore(key, now string, jobs []interface{}) {
+	score, _ := strconv.ParseFloat(now, 64)
+
+	members := make([]redis.Z, len(jobs))
+	for i, job := range jobs {
+		members[i] = redis.Z{Score: score, Member: job}
+	}
+
+	if _, err := Config.Client.ZAdd(key, members...).Result(); err != nil {
+		Logger.Println("ERR: Couldn't put back", len(jobs), "jobs popped from", key, ":", err, jobs)
+	}
+}
+
+func (s *scheduled) reject(job string, reason error) {
+	Logger.Println("ERR: Couldn't promote scheduled job", job, ":", reason)
+
+	if err := quarantine("", "", job, reason); err != nil {
+		Logger.Println("ERR: Couldn't quarantine job", job, ":", err)
+	}
+}
+
+func (s *scheduled) expire(message *Msg) {
+	queue, _ := message.Get("queue").String()
+	queue = strings.TrimPrefix(queue, Config.Namespace)
+
+	Logger.Println("skipping expired job", message.Jid(), "from", queue)
+	discardExpired(queue, message)
 }
 
 func newScheduled(keys ...string) *scheduled {
-	return &scheduled{keys, make(chan bool), make(chan bool)}
+	return &scheduled{keys, processIdentity(), newBackoff(), newLogLimiter(), make(chan bool), make(chan bool)}
 }
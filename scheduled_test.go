@@ -1,7 +1,9 @@
 package workers
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
@@ -34,3 +36,217 @@ func TestScheduled(t *testing.T) {
 	assert.Equal(t, int64(1), myqueueCount)
 	assert.Equal(t, int64(1), pending)
 }
+
+func TestScheduledPollErrors(t *testing.T) {
+	Configure(Options{
+		ServerAddr: "localhost:1",
+		ProcessID:  "1",
+	})
+	defer setupTestConfig()
+
+	scheduled := newScheduled(RETRY_KEY)
+
+	assert.Error(t, scheduled.poll())
+}
+
+func TestScheduledPromotion(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	oldBatchSize := scheduledBatchSize
+	defer func() {
+		scheduledBatchSize = oldBatchSize
+	}()
+	scheduledBatchSize = 2
+
+	scheduled := newScheduled(SCHEDULED_JOBS_KEY)
+
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+
+	for i := 0; i < 5; i++ {
+		rc.ZAdd("prod:"+SCHEDULED_JOBS_KEY, redis.Z{Score: now - float64(i), Member: fmt.Sprintf("{\"queue\":\"default\",\"jid\":\"%d\",\"args\":[],\"enqueued_at\":1}", i)}).Result()
+	}
+	rc.ZAdd("prod:"+SCHEDULED_JOBS_KEY, redis.Z{Score: now - 1, Member: "{\"jid\":\"noqueue\"}"}).Result()
+	rc.ZAdd("prod:"+SCHEDULED_JOBS_KEY, redis.Z{Score: now - 1, Member: "not json"}).Result()
+
+	assert.NoError(t, scheduled.poll())
+
+	//moves every due job, in batches
+	defaultCount, _ := rc.LLen("prod:queue:default").Result()
+	pending, _ := rc.ZCard("prod:" + SCHEDULED_JOBS_KEY).Result()
+	assert.Equal(t, int64(5), defaultCount)
+	assert.Equal(t, int64(0), pending)
+
+	//registers the queue
+	found, _ := rc.SIsMember("prod:queues", "default").Result()
+	assert.True(t, found)
+
+	//updates enqueued_at without otherwise changing the payload
+	job, _ := rc.RPop("prod:queue:default").Result()
+	message, _ := NewMsg(job)
+	enqueuedAt, _ := message.Get("enqueued_at").Float64()
+	assert.InDelta(t, now, enqueuedAt, 1)
+	assert.Equal(t, "[]", message.Args().ToJson())
+
+	//quarantines jobs without a queue
+	quarantined, _ := rc.ZCard("prod:" + QUARANTINE_KEY).Result()
+	assert.Equal(t, int64(2), quarantined)
+}
+
+func TestScheduledPromotionOnlyUpdatesTopLevelEnqueuedAt(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	scheduled := newScheduled(SCHEDULED_JOBS_KEY)
+
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+
+	jobs := []string{
+		`{"queue":"default","args":[{"enqueued_at":5,"note":"\"enqueued_at\":7 {["}],"enqueued_at":1}`,
+		`{"queue":"default","args":[12345678901234567,{}],"enqueued_at":null}`,
+		`{"queue":"default","args":[{"enqueued_at":5}]}`,
+	}
+	for i, job := range jobs {
+		rc.ZAdd("prod:"+SCHEDULED_JOBS_KEY, redis.Z{Score: now - float64(i), Member: job})
+	}
+
+	assert.NoError(t, scheduled.poll())
+
+	promoted, _ := rc.LRange("prod:queue:default", 0, -1).Result()
+	assert.Equal(t, 3, len(promoted))
+
+	//leaves the args alone, however they're encoded
+	expected := []string{
+		`[{"enqueued_at":5,"note":"\"enqueued_at\":7 {["}]`,
+		`[12345678901234567,{}]`,
+		`[{"enqueued_at":5}]`,
+	}
+	for i, job := range promoted {
+		message, err := NewMsg(job)
+		assert.NoError(t, err)
+
+		enqueuedAt, _ := message.Get("enqueued_at").Float64()
+		assert.InDelta(t, now, enqueuedAt, 1)
+		assert.Contains(t, job, expected[i])
+	}
+}
+
+func TestScheduledPromotionInCluster(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	Config.cluster = true
+
+	scheduled := newScheduled(RETRY_KEY)
+
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 60.0, Member: "{\"queue\":\"prod:default\",\"foo\":\"bar1\"}"}).Result()
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now + 60.0, Member: "{\"queue\":\"default\",\"foo\":\"bar2\"}"}).Result()
+
+	assert.NoError(t, scheduled.poll())
+
+	defaultCount, _ := rc.LLen("prod:queue:{default}").Result()
+	pending, _ := rc.ZCard("prod:" + RETRY_KEY).Result()
+	found, _ := rc.SIsMember("prod:queues", "default").Result()
+
+	assert.Equal(t, int64(1), defaultCount)
+	assert.Equal(t, int64(1), pending)
+	assert.True(t, found)
+}
+
+func TestScheduledPromotionInClusterRestoresJobs(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	Config.cluster = true
+
+	scheduled := newScheduled(RETRY_KEY)
+
+	rc := Config.Client
+
+	now := nowToSecondsWithNanoPrecision()
+
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 3, Member: "{\"queue\":\"default\",\"jid\":\"1\"}"}).Result()
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 2, Member: "{\"queue\":\"broken\",\"jid\":\"2\"}"}).Result()
+	rc.ZAdd("prod:"+RETRY_KEY, redis.Z{Score: now - 1, Member: "{\"queue\":\"default\",\"jid\":\"3\"}"}).Result()
+
+	//a queue key that isn't a list makes the second push fail
+	rc.Set("prod:queue:{broken}", "not a list", 0).Result()
+
+	assert.Error(t, scheduled.poll())
+
+	//keeps the job pushed before the failure on its queue
+	defaultCount, _ := rc.LLen("prod:queue:{default}").Result()
+	assert.Equal(t, int64(1), defaultCount)
+
+	//puts the rest back, due now
+	pending, _ := rc.ZRangeByScore("prod:"+RETRY_KEY, redis.ZRangeBy{Min: "-inf", Max: fmt.Sprint(nowToSecondsWithNanoPrecision())}).Result()
+	assert.Equal(t, []string{
+		"{\"queue\":\"broken\",\"jid\":\"2\"}",
+		"{\"queue\":\"default\",\"jid\":\"3\"}",
+	}, pending)
+
+	//promotes them once the queue is fixed
+	rc.Del("prod:queue:{broken}").Result()
+	assert.NoError(t, scheduled.poll())
+
+	defaultCount, _ = rc.LLen("prod:queue:{default}").Result()
+	brokenCount, _ := rc.LLen("prod:queue:{broken}").Result()
+	assert.Equal(t, int64(2), defaultCount)
+	assert.Equal(t, int64(1), brokenCount)
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	Config.SchedulerLeaderElection = true
+
+	leader := newScheduled(RETRY_KEY)
+	leader.identity = "host:1"
+	follower := newScheduled(RETRY_KEY)
+	follower.identity = "host:2"
+
+	//only one process holds the lock
+	lead, err := leader.lead()
+	assert.NoError(t, err)
+	assert.True(t, lead)
+
+	lead, err = follower.lead()
+	assert.NoError(t, err)
+	assert.False(t, lead)
+
+	//the leader keeps it while renewing
+	lead, _ = leader.lead()
+	assert.True(t, lead)
+
+	//and hands it over on quit
+	leader.quit()
+
+	lead, _ = follower.lead()
+	assert.True(t, lead)
+
+	follower.quit()
+}
+
+func TestAdaptivePollInterval(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	Config.PollInterval = 10
+
+	scheduled := newScheduled(RETRY_KEY)
+
+	//defaults to the configured interval
+	assert.Equal(t, 10*time.Second, scheduled.pollInterval())
+
+	//scales by the number of live processes, within 50% of the average
+	Config.AdaptivePollInterval = true
+
+	rc := Config.Client
+	for _, identity := range []string{"host:1", "host:2", "host:3"} {
+		rc.SAdd("prod:processes", identity).Result()
+		rc.HSet("prod:"+identity, "beat", 1).Result()
+	}
+
+	for i := 0; i < 20; i++ {
+		interval := scheduled.pollInterval()
+		assert.True(t, interval >= 15*time.Second && interval < 45*time.Second)
+	}
+}
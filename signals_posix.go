@@ -10,12 +10,16 @@ import (
 
 func handleSignals() {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM, syscall.SIGTSTP, syscall.SIGTTIN)
 
 	for sig := range signals {
 		switch sig {
 		case syscall.SIGINT, syscall.SIGUSR1, syscall.SIGTERM:
 			Quit()
+		case syscall.SIGTSTP:
+			Quiet()
+		case syscall.SIGTTIN:
+			logRunningJobs()
 		}
 	}
 }
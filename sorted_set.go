@@ -0,0 +1,287 @@
+package workers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrJobNotFound is returned when acting on a job that's no longer where
+// it was found, typically because it has already been run or deleted.
+var ErrJobNotFound = errors.New("job not found")
+
+// SortedSet gives access to the jobs waiting in one of the sorted sets,
+// ordered by the time they're due.
+type SortedSet struct {
+	key string
+}
+
+// SortedEntry is a job in a SortedSet, with the time it's due.
+type SortedEntry struct {
+	*Msg
+	At time.Time
+}
+
+// ScheduledSet returns the set of jobs enqueued with EnqueueAt or EnqueueIn.
+func ScheduledSet() *SortedSet {
+	return &SortedSet{SCHEDULED_JOBS_KEY}
+}
+
+// RetrySet returns the set of failed jobs waiting to be retried.
+func RetrySet() *SortedSet {
+	return &SortedSet{RETRY_KEY}
+}
+
+// DeadSet returns the set of jobs that ran out of retries.
+func DeadSet() *SortedSet {
+	return &SortedSet{DEAD_KEY}
+}
+
+func (s *SortedSet) redisKey() string {
+	return Config.Namespace + s.key
+}
+
+// Size returns the number of jobs in the set.
+func (s *SortedSet) Size() (int64, error) {
+	return Config.Client.ZCard(s.redisKey()).Result()
+}
+
+// Page returns up to count jobs starting at offset, soonest due first. A
+// negative count returns every job from offset onwards.
+func (s *SortedSet) Page(offset, count int64) ([]*SortedEntry, error) {
+	stop := offset + count - 1
+	if count < 0 {
+		stop = -1
+	}
+
+	members, err := Config.Client.ZRangeWithScores(s.redisKey(), offset, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.entries(members), nil
+}
+
+// FindJob returns the job with the given JID, or ErrJobNotFound.
+func (s *SortedSet) FindJob(jid string) (*SortedEntry, error) {
+	entries, err := s.scan("jid", jid)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	return entries[0], nil
+}
+
+// FindByClass returns every job of the given class.
+func (s *SortedSet) FindByClass(class string) ([]*SortedEntry, error) {
+	return s.scan("class", class)
+}
+
+// scan looks for jobs whose field matches value, using ZSCAN to narrow
+// the search down on the server before decoding candidates.
+func (s *SortedSet) scan(field, value string) ([]*SortedEntry, error) {
+	rc := Config.Client
+
+	match := "*\"" + field + "\":\"" + escapeGlob(value) + "\"*"
+	found := []*SortedEntry{}
+
+	var cursor uint64
+	for {
+		keys, next, err := rc.ZScan(s.redisKey(), cursor, match, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// ZSCAN returns members and scores interleaved
+		members := make([]redis.Z, 0, len(keys)/2)
+		for i := 0; i+1 < len(keys); i += 2 {
+			score, _ := strconv.ParseFloat(keys[i+1], 64)
+			members = append(members, redis.Z{Member: keys[i], Score: score})
+		}
+
+		for _, entry := range s.entries(members) {
+			if actual, _ := entry.Get(field).String(); actual == value {
+				found = append(found, entry)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return found, nil
+}
+
+func (s *SortedSet) entries(members []redis.Z) []*SortedEntry {
+	entries := make([]*SortedEntry, 0, len(members))
+	for _, member := range members {
+		message, err := NewMsg(member.Member.(string))
+		if err != nil {
+			Logger.Println("ERR: Couldn't decode job in", s.key, ":", err)
+			continue
+		}
+
+		entries = append(entries, &SortedEntry{message, secondsToTime(member.Score)})
+	}
+
+	return entries
+}
+
+// Delete removes a job from the set.
+func (s *SortedSet) Delete(entry *SortedEntry) error {
+	removed, err := Config.Client.ZRem(s.redisKey(), entry.OriginalJson()).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// rescheduleScript changes the score of ARGV[1] in KEYS[1] to ARGV[2], only
+// if it's still in the set.
+var rescheduleScript = redis.NewScript(`
+if redis.call('zrem', KEYS[1], ARGV[1]) == 1 then
+	redis.call('zadd', KEYS[1], ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// Reschedule changes the time a job is due.
+func (s *SortedSet) Reschedule(entry *SortedEntry, at time.Time) error {
+	rescheduled, err := rescheduleScript.Run(Config.Client,
+		[]string{s.redisKey()},
+		entry.OriginalJson(), timeToSecondsWithNanoPrecision(at),
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if rescheduled == 0 {
+		return ErrJobNotFound
+	}
+
+	entry.At = at
+	return nil
+}
+
+// runNowScript moves the job ARGV[1] from the sorted set KEYS[1] onto the
+// queue KEYS[3] as ARGV[2], registering the queue ARGV[3] in the set
+// KEYS[2]. It pushes before removing, since redis doesn't undo a script
+// that fails partway, so a job is never lost.
+var runNowScript = redis.NewScript(`
+if not redis.call('zscore', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('sadd', KEYS[2], ARGV[3])
+redis.call('lpush', KEYS[3], ARGV[2])
+redis.call('zrem', KEYS[1], ARGV[1])
+return 1
+`)
+
+// RunNow removes a job from the set and pushes it onto its queue.
+func (s *SortedSet) RunNow(entry *SortedEntry) error {
+	if Config.cluster {
+		return s.runNowInCluster(entry)
+	}
+
+	queue, err := requeueQueue(entry.Msg)
+	if err != nil {
+		return err
+	}
+	entry.Set("enqueued_at", nowToSecondsWithNanoPrecision())
+
+	ran, err := runNowScript.Run(Config.Client,
+		[]string{s.redisKey(), Config.Namespace + "queues", Config.queueKey(queue)},
+		entry.OriginalJson(), entry.ToJson(), queue,
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if ran == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// runNowInCluster is RunNow for redis cluster, where a script can't touch
+// both the set and the queue. The job is put back if it can't be pushed.
+func (s *SortedSet) runNowInCluster(entry *SortedEntry) error {
+	if err := s.Delete(entry); err != nil {
+		return err
+	}
+
+	if err := requeue(entry.Msg); err != nil {
+		member := redis.Z{Score: timeToSecondsWithNanoPrecision(entry.At), Member: entry.OriginalJson()}
+		if _, addErr := Config.Client.ZAdd(s.redisKey(), member).Result(); addErr != nil {
+			Logger.Println("ERR: Couldn't put back job", entry.OriginalJson(), "in", s.key, ":", addErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Clear removes every job from the set.
+func (s *SortedSet) Clear() error {
+	return Config.Client.Del(s.redisKey()).Err()
+}
+
+// requeue pushes a job taken out of a sorted set onto its queue.
+func requeue(message *Msg) error {
+	rc := Config.Client
+
+	queue, err := requeueQueue(message)
+	if err != nil {
+		return err
+	}
+	message.Set("enqueued_at", nowToSecondsWithNanoPrecision())
+
+	if _, err := rc.SAdd(Config.Namespace+"queues", queue).Result(); err != nil {
+		return err
+	}
+	_, err = rc.LPush(Config.queueKey(queue), message.ToJson()).Result()
+	return err
+}
+
+// requeueQueue returns the queue a job taken out of a sorted set goes back
+// to, without the namespace.
+func requeueQueue(message *Msg) (string, error) {
+	queue, _ := message.Get("queue").String()
+	if queue == "" {
+		return "", errors.New("job has no queue")
+	}
+
+	return strings.TrimPrefix(queue, Config.Namespace), nil
+}
+
+func secondsToTime(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*NanoSecondPrecision))
+}
+
+// escapeGlob escapes the characters redis treats specially in MATCH
+// patterns.
+func escapeGlob(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,132 @@
+package workers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledSet(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	jid1, _ := EnqueueIn("default", "Report", 60, []int{1})
+	jid2, _ := EnqueueIn("default", "Cleanup", 120, []int{2})
+	jid3, _ := EnqueueIn("other", "Report", 180, []int{3})
+
+	set := ScheduledSet()
+
+	size, err := set.Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	//pages through jobs, soonest first
+	page, err := set.Page(0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Equal(t, jid1, page[0].Jid())
+	assert.Equal(t, jid2, page[1].Jid())
+	assert.InDelta(t, time.Now().Add(60*time.Second).Unix(), page[0].At.Unix(), 1)
+
+	page, _ = set.Page(2, 2)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, jid3, page[0].Jid())
+
+	//finds jobs by jid and class
+	entry, err := set.FindJob(jid2)
+	assert.NoError(t, err)
+	assert.Equal(t, jid2, entry.Jid())
+
+	_, err = set.FindJob("missing")
+	assert.Equal(t, ErrJobNotFound, err)
+
+	reports, err := set.FindByClass("Report")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(reports))
+
+	//reschedules jobs
+	at := time.Now().Add(time.Hour)
+	assert.NoError(t, set.Reschedule(entry, at))
+	page, _ = set.Page(0, -1)
+	assert.Equal(t, jid2, page[2].Jid())
+	assert.InDelta(t, at.Unix(), page[2].At.Unix(), 1)
+
+	//runs jobs now
+	entry, _ = set.FindJob(jid3)
+	assert.NoError(t, set.RunNow(entry))
+
+	job, _ := rc.LPop("prod:queue:other").Result()
+	var data EnqueueData
+	json.Unmarshal([]byte(job), &data)
+	assert.Equal(t, jid3, data.Jid)
+	assert.InDelta(t, nowToSecondsWithNanoPrecision(), data.EnqueuedAt, 1)
+
+	assert.Equal(t, ErrJobNotFound, set.RunNow(entry))
+
+	//deletes jobs
+	entry, _ = set.FindJob(jid1)
+	assert.NoError(t, set.Delete(entry))
+	assert.Equal(t, ErrJobNotFound, set.Delete(entry))
+	assert.Equal(t, ErrJobNotFound, set.Reschedule(entry, at))
+
+	size, _ = set.Size()
+	assert.Equal(t, int64(1), size)
+
+	assert.NoError(t, set.Clear())
+	size, _ = set.Size()
+	assert.Equal(t, int64(0), size)
+}
+
+func TestRetrySet(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	message, _ := NewMsg("{\"jid\":\"2\",\"class\":\"Flaky\",\"queue\":\"prod:default\",\"retry\":true,\"retry_count\":1}")
+	retryProcessError("prod:default", message, nil)
+
+	set := RetrySet()
+
+	entry, err := set.FindJob("2")
+	assert.NoError(t, err)
+	assert.True(t, entry.At.After(time.Now()))
+
+	assert.NoError(t, set.RunNow(entry))
+
+	count, _ := rc.LLen("prod:queue:default").Result()
+	assert.Equal(t, int64(1), count)
+
+	size, _ := set.Size()
+	assert.Equal(t, int64(0), size)
+}
+
+func TestRunNowKeepsJobsItCantPush(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	jid, _ := EnqueueIn("broken", "Report", 60, []int{1})
+
+	//a queue key that isn't a list makes the push fail
+	rc.Set("prod:queue:broken", "not a list", 0).Result()
+
+	set := ScheduledSet()
+	entry, _ := set.FindJob(jid)
+	assert.Error(t, set.RunNow(entry))
+
+	_, err := set.FindJob(jid)
+	assert.NoError(t, err)
+
+	//in cluster mode too
+	Config.cluster = true
+	defer func() {
+		Config.cluster = false
+	}()
+	rc.Set("prod:queue:{broken}", "not a list", 0).Result()
+
+	assert.Error(t, set.RunNow(entry))
+
+	found, err := set.FindJob(jid)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.At.Unix(), found.At.Unix())
+}
@@ -10,11 +10,19 @@ import (
 )
 
 type stats struct {
-	Processed int         `json:"processed"`
-	Failed    int         `json:"failed"`
-	Jobs      interface{} `json:"jobs"`
-	Enqueued  interface{} `json:"enqueued"`
-	Retries   int64       `json:"retries"`
+	Processed   int         `json:"processed"`
+	Failed      int         `json:"failed"`
+	Cancelled   int         `json:"cancelled"`
+	Expired     int         `json:"expired"`
+	Jobs        interface{} `json:"jobs"`
+	Enqueued    interface{} `json:"enqueued"`
+	Retries     int64       `json:"retries"`
+	Dead        int64       `json:"dead"`
+	Quarantined int64       `json:"quarantined"`
+
+	BrokerAvailable        bool    `json:"broker_available"`
+	BrokerUnavailableSince float64 `json:"broker_unavailable_since,omitempty"`
+	BrokerError            string  `json:"broker_error,omitempty"`
 }
 
 func Stats(w http.ResponseWriter, req *http.Request) {
@@ -23,30 +31,39 @@ func Stats(w http.ResponseWriter, req *http.Request) {
 
 	jobs := make(map[string][]*map[string]interface{})
 	enqueued := make(map[string]string)
+	current := currentManagers()
 
-	for _, m := range managers {
+	for _, m := range current {
 		queue := m.queueName()
 		jobs[queue] = make([]*map[string]interface{}, 0)
 		enqueued[queue] = ""
-		for _, worker := range m.workers {
-			message := worker.currentMsg
-			startedAt := worker.startedAt
-
-			if message != nil && startedAt > 0 {
-				jobs[queue] = append(jobs[queue], &map[string]interface{}{
-					"message":    message,
-					"started_at": startedAt,
-				})
-			}
+		for _, running := range m.running() {
+			jobs[queue] = append(jobs[queue], &map[string]interface{}{
+				"message":    running.message,
+				"started_at": running.at.Unix(),
+			})
 		}
 	}
 
 	stats := stats{
+		0,
+		0,
 		0,
 		0,
 		jobs,
 		enqueued,
 		0,
+		0,
+		0,
+		true,
+		0,
+		"",
+	}
+
+	if available, since, err := broker.status(); !available {
+		stats.BrokerAvailable = false
+		stats.BrokerUnavailableSince = timeToSecondsWithNanoPrecision(since)
+		stats.BrokerError = fmt.Sprint(err)
 	}
 
 	rc := Config.Client
@@ -54,29 +71,113 @@ func Stats(w http.ResponseWriter, req *http.Request) {
 	pipe := rc.Pipeline()
 	pGet := pipe.Get(Config.Namespace + "stat:processed")
 	fGet := pipe.Get(Config.Namespace + "stat:failed")
+	cGet := pipe.Get(Config.Namespace + "stat:cancelled")
+	eGet := pipe.Get(Config.Namespace + "stat:expired")
 	rGet := pipe.ZCard(Config.Namespace + RETRY_KEY)
+	dGet := pipe.ZCard(Config.Namespace + DEAD_KEY)
+	qGet := pipe.ZCard(Config.Namespace + QUARANTINE_KEY)
 
-	var qLen []*redis.IntCmd
-	for key, _ := range enqueued {
-		qLen = append(qLen, pipe.LLen(fmt.Sprintf("%squeue:%s", Config.Namespace, key)))
+	qLen := make(map[string]*redis.IntCmd)
+	for _, m := range current {
+		qLen[m.queueName()] = pipe.LLen(m.queue)
 	}
 
+	// Counters that were never incremented don't exist yet
 	_, err := pipe.Exec()
 
-	if err != nil {
+	if err != nil && err != redis.Nil {
 		Logger.Println("couldn't retrieve stats:", err)
 	} else {
 		stats.Processed, _ = strconv.Atoi(pGet.Val())
 		stats.Failed, _ = strconv.Atoi(fGet.Val())
+		stats.Cancelled, _ = strconv.Atoi(cGet.Val())
+		stats.Expired, _ = strconv.Atoi(eGet.Val())
 		stats.Retries = rGet.Val()
+		stats.Dead = dGet.Val()
+		stats.Quarantined = qGet.Val()
 
-		queueIndex := 0
 		for key, _ := range enqueued {
-			enqueued[key] = fmt.Sprintf("%d", qLen[queueIndex].Val())
-			queueIndex++
+			enqueued[key] = fmt.Sprintf("%d", qLen[key].Val())
 		}
 	}
 
 	body, _ := json.MarshalIndent(stats, "", "  ")
 	fmt.Fprintln(w, string(body))
 }
+
+// Health responds with 503 Service Unavailable while redis can't be reached
+// by the fetch or scheduler loops, and 200 OK otherwise.
+func Health(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	status := "ok"
+	if !BrokerAvailable() {
+		status = "broker unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	body, _ := json.Marshal(map[string]string{"status": status})
+	fmt.Fprintln(w, string(body))
+}
+
+// Totals are the counters and sizes shared by every process.
+type Totals struct {
+	Processed   int64            `json:"processed"`
+	Failed      int64            `json:"failed"`
+	Cancelled   int64            `json:"cancelled"`
+	Expired     int64            `json:"expired"`
+	Scheduled   int64            `json:"scheduled"`
+	Retries     int64            `json:"retries"`
+	Dead        int64            `json:"dead"`
+	Quarantined int64            `json:"quarantined"`
+	Enqueued    map[string]int64 `json:"enqueued"`
+}
+
+// StatsTotals returns the processed, failed, cancelled and expired counters,
+// the size of each sorted set, and the size of every known queue.
+func StatsTotals() (*Totals, error) {
+	queues, err := Queues()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	processed := pipe.Get(Config.Namespace + "stat:processed")
+	failed := pipe.Get(Config.Namespace + "stat:failed")
+	cancelled := pipe.Get(Config.Namespace + "stat:cancelled")
+	expired := pipe.Get(Config.Namespace + "stat:expired")
+	scheduled := pipe.ZCard(Config.Namespace + SCHEDULED_JOBS_KEY)
+	retries := pipe.ZCard(Config.Namespace + RETRY_KEY)
+	dead := pipe.ZCard(Config.Namespace + DEAD_KEY)
+	quarantined := pipe.ZCard(Config.Namespace + QUARANTINE_KEY)
+
+	sizes := make(map[string]*redis.IntCmd)
+	for _, queue := range queues {
+		sizes[queue.Name()] = pipe.LLen(queue.redisKey())
+	}
+
+	// Counters that were never incremented don't exist yet
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	totals := &Totals{
+		Scheduled:   scheduled.Val(),
+		Retries:     retries.Val(),
+		Dead:        dead.Val(),
+		Quarantined: quarantined.Val(),
+		Enqueued:    make(map[string]int64),
+	}
+	totals.Processed, _ = strconv.ParseInt(processed.Val(), 10, 64)
+	totals.Failed, _ = strconv.ParseInt(failed.Val(), 10, 64)
+	totals.Cancelled, _ = strconv.ParseInt(cancelled.Val(), 10, 64)
+	totals.Expired, _ = strconv.ParseInt(expired.Val(), 10, 64)
+
+	for name, size := range sizes {
+		totals.Enqueued[name] = size.Val()
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,216 @@
+package workers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// This is a variable for testing reasons
+var statusTTL = 24 * time.Hour
+
+// The states a job with TrackStatus goes through.
+const (
+	StatusQueued    = "queued"
+	StatusScheduled = "scheduled"
+	StatusRunning   = "running"
+	StatusRetrying  = "retrying"
+	StatusFailed    = "failed"
+	StatusDead      = "dead"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+	StatusComplete  = "complete"
+)
+
+// JobStatus is the last known state of a job enqueued with TrackStatus.
+type JobStatus struct {
+	Jid       string    `json:"jid"`
+	Status    string    `json:"status"`
+	Queue     string    `json:"queue"`
+	Class     string    `json:"class"`
+	Progress  int       `json:"progress"`
+	Message   string    `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Done reports whether the job has reached a state it won't leave.
+func (s *JobStatus) Done() bool {
+	switch s.Status {
+	case StatusFailed, StatusDead, StatusCancelled, StatusExpired, StatusComplete:
+		return true
+	}
+	return false
+}
+
+// Status returns the state of a job enqueued with TrackStatus, or
+// ErrJobNotFound if it isn't tracked or its status has expired. Statuses
+// are kept for 24 hours after their last update.
+func Status(jid string) (*JobStatus, error) {
+	fields, err := Config.Client.HGetAll(statusKey(jid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	status := &JobStatus{
+		Jid:     jid,
+		Status:  fields["status"],
+		Queue:   fields["queue"],
+		Class:   fields["class"],
+		Message: fields["message"],
+		Error:   fields["error"],
+	}
+	status.Progress, _ = strconv.Atoi(fields["progress"])
+	if updatedAt, err := strconv.ParseFloat(fields["updated_at"], 64); err == nil {
+		status.UpdatedAt = secondsToTime(updatedAt)
+	}
+
+	return status, nil
+}
+
+// Progress reports how far along a job with TrackStatus is, as a
+// percentage and an optional message. It does nothing for other jobs.
+func (m *Msg) Progress(percent int, message string) error {
+	if !m.tracked() {
+		return nil
+	}
+
+	return setStatus(m.Jid(), map[string]interface{}{
+		"progress": percent,
+		"message":  message,
+	})
+}
+
+func (m *Msg) tracked() bool {
+	tracked, _ := m.Get("track_status").Bool()
+	return tracked
+}
+
+// StatusMiddleware records the state of jobs enqueued with TrackStatus as
+// they run, fail, get retried or finish. It's one of the default
+// middlewares, and should run outside RetryMiddleware.
+func StatusMiddleware(queue string, next JobFunc) JobFunc {
+	return func(message *Msg) (err error) {
+		if !message.tracked() {
+			return next(message)
+		}
+
+		jid := message.Jid()
+		before := RetrySnapshot(message)
+
+		updateStatus(jid, map[string]interface{}{
+			"status": StatusRunning,
+			"error":  "",
+		})
+
+		defer func() {
+			e := recover()
+			if e != nil {
+				err = fmt.Errorf("%v", e)
+			}
+
+			fields := map[string]interface{}{"status": JobOutcome(message, before, err)}
+			switch fields["status"] {
+			case StatusComplete:
+				fields["progress"] = 100
+			case StatusRetrying:
+				fields["error"], _ = message.Get("error_message").String()
+			case StatusFailed, StatusDead:
+				fields["error"] = err.Error()
+			}
+			updateStatus(jid, fields)
+
+			if e != nil {
+				panic(e)
+			}
+		}()
+
+		return next(message)
+	}
+}
+
+// RetryState is a job's retry count at some point in time, taken with
+// RetrySnapshot.
+type RetryState struct {
+	count   int
+	retried bool
+}
+
+// RetrySnapshot takes a job's retry count, before running it, for
+// JobOutcome. A missing or malformed retry count means the job hasn't been
+// retried.
+func RetrySnapshot(message *Msg) RetryState {
+	count, err := message.Get("retry_count").Int()
+	if err != nil {
+		return RetryState{}
+	}
+	return RetryState{count, true}
+}
+
+// JobOutcome works out what happened to a job once the middlewares inside
+// the caller are done with it, given the RetrySnapshot taken before running
+// it. It's for middlewares that wrap RetryMiddleware, which returns nil
+// once it has saved a job for retry, so retries are spotted by the retry
+// count changing. It returns StatusComplete, StatusRetrying,
+// StatusCancelled, StatusDead or StatusFailed.
+func JobOutcome(message *Msg, before RetryState, err error) string {
+	switch {
+	case RetrySnapshot(message) != before:
+		return StatusRetrying
+	case err == nil:
+		return StatusComplete
+	case err == ErrCancelled || message.cancelled():
+		return StatusCancelled
+	}
+
+	if enabled, _ := retryOptions(message); enabled {
+		return StatusDead
+	}
+	return StatusFailed
+}
+
+// enqueuedStatus records a tracked job as queued or scheduled.
+func enqueuedStatus(pipe redis.Pipeliner, data EnqueueData, scheduled bool) {
+	status := StatusQueued
+	if scheduled {
+		status = StatusScheduled
+	}
+
+	writeStatus(pipe, data.Jid, map[string]interface{}{
+		"status":   status,
+		"queue":    data.Queue,
+		"class":    data.Class,
+		"progress": 0,
+	})
+}
+
+func updateStatus(jid string, fields map[string]interface{}) {
+	if err := setStatus(jid, fields); err != nil {
+		Logger.Println("ERR: Couldn't update status of", jid, ":", err)
+	}
+}
+
+func setStatus(jid string, fields map[string]interface{}) error {
+	pipe := Config.Client.Pipeline()
+	writeStatus(pipe, jid, fields)
+	_, err := pipe.Exec()
+	return err
+}
+
+// writeStatus queues a status update on pipe, so it can be written along
+// with the job.
+func writeStatus(pipe redis.Pipeliner, jid string, fields map[string]interface{}) {
+	fields["updated_at"] = nowToSecondsWithNanoPrecision()
+
+	pipe.HMSet(statusKey(jid), fields)
+	pipe.Expire(statusKey(jid), statusTTL)
+}
+
+func statusKey(jid string) string {
+	return Config.Namespace + STATUS_KEY + ":" + jid
+}
@@ -0,0 +1,124 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	rc := Config.Client
+
+	//doesn't track jobs by default
+	jid, _ := Enqueue("myqueue", "Add", []int{1, 2})
+	_, err := Status(jid)
+	assert.Equal(t, ErrJobNotFound, err)
+
+	//tracks queued and scheduled jobs
+	jid, _ = EnqueueWithOptions("myqueue", "Add", []int{1, 2}, EnqueueOptions{TrackStatus: true})
+
+	status, err := Status(jid)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusQueued, status.Status)
+	assert.Equal(t, "myqueue", status.Queue)
+	assert.Equal(t, "Add", status.Class)
+	assert.False(t, status.Done())
+
+	ttl, _ := rc.TTL("prod:status:" + jid).Result()
+	assert.True(t, ttl > 0)
+
+	scheduled, _ := EnqueueWithOptions("myqueue", "Add", []int{1, 2}, EnqueueOptions{
+		TrackStatus: true,
+		At:          nowToSecondsWithNanoPrecision() + 60,
+	})
+	status, _ = Status(scheduled)
+	assert.Equal(t, StatusScheduled, status.Status)
+}
+
+func TestStatusMiddleware(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	mids := NewMiddlewares(StatusMiddleware, RetryMiddleware)
+
+	//reports progress while running
+	message, _ := NewMsg("{\"jid\":\"1\",\"track_status\":true}")
+	err := mids.build("myqueue", func(message *Msg) error {
+		status, _ := Status("1")
+		assert.Equal(t, StatusRunning, status.Status)
+
+		assert.NoError(t, message.Progress(50, "halfway"))
+		status, _ = Status("1")
+		assert.Equal(t, 50, status.Progress)
+		assert.Equal(t, "halfway", status.Message)
+		return nil
+	})(message)
+	assert.NoError(t, err)
+
+	status, _ := Status("1")
+	assert.Equal(t, StatusComplete, status.Status)
+	assert.Equal(t, 100, status.Progress)
+	assert.True(t, status.Done())
+
+	failing := mids.build("myqueue", func(message *Msg) error {
+		return errors.New("AHHHH")
+	})
+
+	//reports failures by what happens to the job next
+	message, _ = NewMsg("{\"jid\":\"2\",\"track_status\":true,\"retry\":true}")
+	failing(message)
+	status, _ = Status("2")
+	assert.Equal(t, StatusRetrying, status.Status)
+	assert.Equal(t, "AHHHH", status.Error)
+
+	message, _ = NewMsg("{\"jid\":\"3\",\"track_status\":true,\"retry\":1,\"retry_count\":1}")
+	failing(message)
+	status, _ = Status("3")
+	assert.Equal(t, StatusDead, status.Status)
+
+	message, _ = NewMsg("{\"jid\":\"4\",\"track_status\":true}")
+	failing(message)
+	status, _ = Status("4")
+	assert.Equal(t, StatusFailed, status.Status)
+
+	//records cancelled jobs
+	message, _ = NewMsg("{\"jid\":\"5\",\"track_status\":true,\"retry\":true}")
+	defer message.withCancel()()
+	message.cancel()
+	failing(message)
+	status, _ = Status("5")
+	assert.Equal(t, StatusCancelled, status.Status)
+
+	//ignores progress for untracked jobs
+	message, _ = NewMsg("{\"jid\":\"6\"}")
+	assert.NoError(t, message.Progress(50, ""))
+	_, err = Status("6")
+	assert.Equal(t, ErrJobNotFound, err)
+}
+
+func TestJobOutcome(t *testing.T) {
+	message, _ := NewMsg("{\"jid\":\"1\",\"retry\":true,\"retry_count\":[1]}")
+	before := RetrySnapshot(message)
+
+	//treats malformed retry counts as not retried
+	assert.Equal(t, RetryState{}, before)
+	assert.Equal(t, StatusComplete, JobOutcome(message, before, nil))
+	assert.Equal(t, StatusDead, JobOutcome(message, before, errors.New("AHHHH")))
+
+	//spots the retry middleware replacing it
+	incrementRetry(message)
+	assert.Equal(t, StatusRetrying, JobOutcome(message, before, nil))
+
+	message, _ = NewMsg("{\"jid\":\"2\",\"retry\":true,\"retry_count\":{\"count\":1}}")
+	before = RetrySnapshot(message)
+	message.Set("retry_count", map[string]interface{}{"count": 2})
+	assert.Equal(t, StatusDead, JobOutcome(message, before, errors.New("AHHHH")))
+
+	//spots retry counts going up
+	message, _ = NewMsg("{\"jid\":\"3\",\"retry\":true,\"retry_count\":1}")
+	before = RetrySnapshot(message)
+	assert.Equal(t, RetryState{1, true}, before)
+	incrementRetry(message)
+	assert.Equal(t, StatusRetrying, JobOutcome(message, before, nil))
+}
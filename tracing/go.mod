@@ -0,0 +1,13 @@
+module github.com/digitalocean/go-workers2/tracing
+
+// Builds against the root module in this repository; consumers get the
+// tagged release required below.
+replace github.com/digitalocean/go-workers2 => ../
+
+require (
+	github.com/digitalocean/go-workers2 v0.11.0
+	github.com/stretchr/testify v1.7.1
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+)
@@ -0,0 +1,186 @@
+// Package tracing traces jobs with OpenTelemetry, following requests from
+// the code that enqueues jobs into the jobs themselves.
+//
+// ClientMiddleware records a producer span for each job enqueued with
+// workers.EnqueueContext, and injects its W3C trace context into the job's
+// metadata. Middleware continues the trace with a consumer span for each
+// job it runs:
+//
+//	workers.UseClientMiddleware(tracing.ClientMiddleware())
+//	workers.Process("myqueue", job, 10, workers.DefaultMiddlewares().Prepend(tracing.Middleware())...)
+//
+// Jobs that enqueue other jobs with workers.EnqueueContext(message.Context(), ...)
+// carry the trace on.
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	workers "github.com/digitalocean/go-workers2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/digitalocean/go-workers2/tracing"
+
+// The attributes set on spans.
+const (
+	SystemKey     = attribute.Key("messaging.system")
+	OperationKey  = attribute.Key("messaging.operation.type")
+	QueueKey      = attribute.Key("messaging.destination.name")
+	JidKey        = attribute.Key("messaging.message.id")
+	ClassKey      = attribute.Key("workers.class")
+	RetryCountKey = attribute.Key("workers.retry_count")
+)
+
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// Option configures the middlewares.
+type Option func(*config)
+
+// WithTracerProvider sets the provider of the tracer spans are started
+// with, otel.GetTracerProvider() by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithPropagator sets how trace context is carried in job metadata, W3C
+// trace context and baggage by default.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		provider:   otel.GetTracerProvider(),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *config) tracer() trace.Tracer {
+	return c.provider.Tracer(instrumentationName)
+}
+
+// ClientMiddleware records a producer span for each enqueued job, as a
+// child of the span in the context it's enqueued with, and injects the
+// span's trace context into the job's metadata.
+func ClientMiddleware(opts ...Option) workers.ClientMiddlewareFunc {
+	c := newConfig(opts)
+	tracer := c.tracer()
+
+	return func(next workers.EnqueueFunc) workers.EnqueueFunc {
+		return func(data *workers.EnqueueData) error {
+			ctx, span := tracer.Start(data.Context(), data.Queue+" send",
+				trace.WithSpanKind(trace.SpanKindProducer),
+				trace.WithAttributes(
+					SystemKey.String("go-workers2"),
+					OperationKey.String("send"),
+					QueueKey.String(data.Queue),
+					JidKey.String(data.Jid),
+					ClassKey.String(data.Class),
+				),
+			)
+			defer span.End()
+
+			if data.Metadata == nil {
+				data.Metadata = workers.Metadata{}
+			}
+			c.propagator.Inject(ctx, data.Metadata)
+
+			err := next(data)
+			if err != nil {
+				recordError(span, err)
+			}
+			return err
+		}
+	}
+}
+
+// Middleware records a consumer span for each job, continuing the trace
+// from its metadata, and puts it in the job's Context. Failed jobs record
+// their error, and jobs that will be retried get a retry event. Prepend
+// it to the other middlewares, so the span covers them and sees retries.
+func Middleware(opts ...Option) workers.MiddlewareFunc {
+	c := newConfig(opts)
+	tracer := c.tracer()
+
+	return func(queue string, next workers.JobFunc) workers.JobFunc {
+		queue = strings.TrimPrefix(queue, workers.Config.Namespace)
+
+		return func(message *workers.Msg) (err error) {
+			class, _ := message.Get("class").String()
+			before := workers.RetrySnapshot(message)
+			retryCount, retried := message.Get("retry_count").Int()
+
+			attributes := []attribute.KeyValue{
+				SystemKey.String("go-workers2"),
+				OperationKey.String("process"),
+				QueueKey.String(queue),
+				JidKey.String(message.Jid()),
+				ClassKey.String(class),
+			}
+			if retried == nil {
+				attributes = append(attributes, RetryCountKey.Int(retryCount))
+			}
+
+			parent := c.propagator.Extract(message.Context(), message.Metadata())
+			ctx, span := tracer.Start(parent, queue+" process",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(attributes...),
+			)
+			message.SetContext(ctx)
+
+			defer func() {
+				failure := err
+				e := recover()
+				if e != nil {
+					failure = fmt.Errorf("%v", e)
+				}
+
+				//the retry middleware returns no error once it has
+				//scheduled a retry, keeping it in the job instead
+				retrying := workers.JobOutcome(message, before, failure) == workers.StatusRetrying
+				if failure == nil && retrying {
+					reason, _ := message.Get("error_message").String()
+					failure = errors.New(reason)
+				}
+
+				if failure != nil {
+					recordError(span, failure)
+				}
+				if retrying {
+					count, _ := message.Get("retry_count").Int()
+					span.AddEvent("retry", trace.WithAttributes(RetryCountKey.Int(count)))
+				}
+
+				span.End()
+				if e != nil {
+					panic(e)
+				}
+			}()
+
+			return next(message)
+		}
+	}
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
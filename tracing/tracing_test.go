@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	workers "github.com/digitalocean/go-workers2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTestConfig() {
+	//a database of its own, as the main package's tests flush theirs
+	workers.Configure(workers.Options{
+		ServerAddr: "localhost:6379",
+		ProcessID:  "1",
+		Database:   14,
+		PoolSize:   1,
+		Namespace:  "prod",
+	})
+	workers.Config.Client.FlushDB()
+}
+
+func setupTracing() (*tracetest.InMemoryExporter, Option) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return exporter, WithTracerProvider(provider)
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestTracing(t *testing.T) {
+	setupTestConfig()
+	exporter, provider := setupTracing()
+	workers.UseClientMiddleware(ClientMiddleware(provider))
+
+	//injects the trace context into the payload
+	ctx, parent := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "request")
+	jid, err := workers.EnqueueContext(ctx, "tracingQueue", "Add", []int{1, 2}, workers.EnqueueOptions{Retry: true})
+	assert.NoError(t, err)
+	parent.End()
+
+	payload, _ := workers.Config.Client.LIndex("prod:queue:tracingQueue", 0).Result()
+	message, _ := workers.NewMsg(payload)
+	assert.NotEmpty(t, message.Metadata().Get("traceparent"))
+
+	//records a producer span for the request
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	producer := spans[0]
+	assert.Equal(t, "tracingQueue send", producer.Name)
+	assert.Equal(t, trace.SpanKindProducer, producer.SpanKind)
+	assert.Equal(t, parent.SpanContext().TraceID(), producer.SpanContext.TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), producer.Parent.SpanID())
+	assert.Equal(t, jid, attributes(producer)[JidKey].AsString())
+	exporter.Reset()
+
+	//continues the trace in the job
+	var jobSpan trace.SpanContext
+	job := Middleware(provider)("prod:tracingQueue", func(message *workers.Msg) error {
+		jobSpan = trace.SpanContextFromContext(message.Context())
+		return nil
+	})
+	assert.NoError(t, job(message))
+
+	spans = exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	consumer := spans[0]
+	assert.Equal(t, "tracingQueue process", consumer.Name)
+	assert.Equal(t, trace.SpanKindConsumer, consumer.SpanKind)
+	assert.Equal(t, producer.SpanContext.TraceID(), consumer.SpanContext.TraceID())
+	assert.Equal(t, producer.SpanContext.SpanID(), consumer.Parent.SpanID())
+	assert.Equal(t, consumer.SpanContext.SpanID(), jobSpan.SpanID())
+	assert.Equal(t, codes.Unset, consumer.Status.Code)
+
+	values := attributes(consumer)
+	assert.Equal(t, "tracingQueue", values[QueueKey].AsString())
+	assert.Equal(t, "Add", values[ClassKey].AsString())
+	assert.Equal(t, jid, values[JidKey].AsString())
+	_, found := values[RetryCountKey]
+	assert.False(t, found)
+}
+
+func TestTracingErrors(t *testing.T) {
+	setupTestConfig()
+	exporter, provider := setupTracing()
+
+	//records errors and retries
+	message, _ := workers.NewMsg("{\"jid\":\"2\",\"class\":\"Fail\",\"retry\":true}")
+	job := Middleware(provider)("prod:tracingQueue", workers.RetryMiddleware("prod:tracingQueue", func(message *workers.Msg) error {
+		return errors.New("AHHHH")
+	}))
+	assert.NoError(t, job(message))
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	span := spans[0]
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Equal(t, "AHHHH", span.Status.Description)
+	assert.Equal(t, []string{"exception", "retry"}, []string{span.Events[0].Name, span.Events[1].Name})
+	assert.False(t, span.Parent.IsValid())
+	exporter.Reset()
+
+	//tags retried jobs with their retry count
+	retries, _ := workers.RetrySet().Page(0, -1)
+	assert.Equal(t, 1, len(retries))
+	assert.NoError(t, job(retries[0].Msg))
+
+	span = exporter.GetSpans()[0]
+	assert.Equal(t, int64(0), attributes(span)[RetryCountKey].AsInt64())
+	assert.Equal(t, "retry", span.Events[1].Name)
+	exporter.Reset()
+
+	//records panics
+	job = Middleware(provider)("prod:tracingQueue", func(message *workers.Msg) error {
+		panic("AHHHH")
+	})
+	assert.Panics(t, func() {
+		job(message)
+	})
+
+	span = exporter.GetSpans()[0]
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Equal(t, "AHHHH", span.Status.Description)
+}
@@ -2,6 +2,7 @@ package workers
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -11,6 +12,7 @@ type worker struct {
 	stop       chan bool
 	exit       chan bool
 	currentMsg *Msg
+	currentM   sync.Mutex
 	startedAt  int64
 }
 
@@ -27,17 +29,25 @@ func (w *worker) work(messages chan *Msg) {
 	for {
 		select {
 		case message := <-messages:
-			atomic.StoreInt64(&w.startedAt, time.Now().UTC().Unix())
-			w.currentMsg = message
+			done := message.withCancel()
+			w.setCurrent(message)
 
-			w.process(message)
+			if Config.AtMostOnce {
+				// Acknowledge before running so the job is never
+				// executed twice, even if this process dies mid-job.
+				if w.manager.acknowledge(message) {
+					w.process(message)
+				}
+			} else {
+				w.process(message)
 
-			if message.ack {
-				w.manager.confirm <- message
+				if message.ack {
+					w.manager.confirm <- message
+				}
 			}
 
-			atomic.StoreInt64(&w.startedAt, 0)
-			w.currentMsg = nil
+			w.setCurrent(nil)
+			done()
 
 			// Attempt to tell fetcher we're finished.
 			// Can be used when the fetcher has slept due
@@ -70,10 +80,33 @@ func (w *worker) process(message *Msg) (err error) {
 	return w.manager.handler(message)
 }
 
+func (w *worker) setCurrent(message *Msg) {
+	w.currentM.Lock()
+	defer w.currentM.Unlock()
+
+	w.currentMsg = message
+	if message != nil {
+		atomic.StoreInt64(&w.startedAt, time.Now().UTC().Unix())
+	} else {
+		atomic.StoreInt64(&w.startedAt, 0)
+	}
+}
+
+// current returns the job being processed and when it started, or nil.
+func (w *worker) current() (*Msg, time.Time) {
+	w.currentM.Lock()
+	defer w.currentM.Unlock()
+
+	if w.currentMsg == nil {
+		return nil, time.Time{}
+	}
+	return w.currentMsg, time.Unix(atomic.LoadInt64(&w.startedAt), 0)
+}
+
 func (w *worker) processing() bool {
 	return atomic.LoadInt64(&w.startedAt) > 0
 }
 
 func newWorker(m *manager) *worker {
-	return &worker{m, make(chan bool), make(chan bool), nil, 0}
+	return &worker{manager: m, stop: make(chan bool), exit: make(chan bool)}
 }
@@ -191,3 +191,36 @@ func TestRecoverWithError(t *testing.T) {
 
 	worker.quit()
 }
+
+func TestAtMostOnce(t *testing.T) {
+	setupTestConfig()
+	Config.AtMostOnce = true
+	defer func() {
+		Config.AtMostOnce = false
+	}()
+
+	rc := Config.Client
+
+	inprogress := make(chan int64)
+	var testJob = (func(message *Msg) error {
+		count, _ := rc.LLen("queue:myqueue:1:inprogress").Result()
+		inprogress <- count
+		return nil
+	})
+
+	manager := newManager("myqueue", testJob, 1)
+	worker := newWorker(manager)
+	messages := make(chan *Msg)
+	message, _ := NewMsg("{\"jid\":\"2309823\",\"args\":[\"foo\",\"bar\"]}")
+
+	rc.LPush("queue:myqueue:1:inprogress", message.OriginalJson()).Result()
+
+	//acknowledges before the job runs, and doesn't confirm afterwards
+	go worker.work(messages)
+	messages <- message
+
+	assert.Equal(t, int64(0), <-inprogress)
+	assert.Nil(t, confirm(manager))
+
+	worker.quit()
+}
@@ -12,21 +12,47 @@ import (
 const (
 	RETRY_KEY          = "goretry"
 	SCHEDULED_JOBS_KEY = "schedule"
+	QUARANTINE_KEY     = "quarantine"
+	DEAD_KEY           = "dead"
+	PAUSED_QUEUES_KEY  = "paused"
+
+	SCHEDULER_LEADER_KEY = "scheduler:leader"
+
+	PERIODIC_KEY          = "periodic"
+	PERIODIC_LAST_RUN_KEY = "periodic:last_run"
+	PERIODIC_DISABLED_KEY = "periodic:disabled"
+
+	CANCELLED_KEY  = "cancelled"
+	CANCEL_CHANNEL = "cancel"
+
+	STATUS_KEY = "status"
+	RESULT_KEY = "result"
+	BATCH_KEY  = "batch"
+
+	WORKFLOW_KEY   = "workflow"
+	RATE_LIMIT_KEY = "limit"
 )
 
 var Logger WorkersLogger = log.New(os.Stdout, "workers: ", log.Ldate|log.Lmicroseconds)
 
 var managers = make(map[string]*manager)
+var managersM sync.Mutex
 var schedule *scheduled
-var control = make(map[string]chan string)
+var beat *heartbeat
+var cron *periodic
+var cancels *canceller
 var access sync.Mutex
 var started bool
+var quiet bool
+var quietM sync.Mutex
 
 func Process(queue string, job JobFunc, concurrency int, mids ...MiddlewareFunc) {
 	access.Lock()
 	defer access.Unlock()
 
+	managersM.Lock()
 	managers[queue] = newManager(queue, job, concurrency, mids...)
+	managersM.Unlock()
 }
 
 func Run() {
@@ -43,11 +69,27 @@ func ResetManagers() error {
 		return errors.New("Cannot reset worker managers while workers are running")
 	}
 
+	managersM.Lock()
 	managers = make(map[string]*manager)
+	managersM.Unlock()
 
 	return nil
 }
 
+// currentManagers returns the managers processing each queue. The map is
+// only changed while holding access, but it has its own lock so heartbeats
+// and cancellations don't wait on Quit.
+func currentManagers() []*manager {
+	managersM.Lock()
+	defer managersM.Unlock()
+
+	current := make([]*manager, 0, len(managers))
+	for _, m := range managers {
+		current = append(current, m)
+	}
+	return current
+}
+
 func Start() {
 	access.Lock()
 	defer access.Unlock()
@@ -57,7 +99,10 @@ func Start() {
 	}
 
 	runHooks(beforeStart)
+	startHeartbeat()
+	startCanceller()
 	startSchedule()
+	startPeriodic()
 	startManagers()
 
 	started = true
@@ -73,14 +118,83 @@ func Quit() {
 
 	quitManagers()
 	quitSchedule()
+	quitPeriodic()
 	runHooks(duringDrain)
 	waitForExit()
+	quitCanceller()
+	quitHeartbeat()
 
 	started = false
+
+	quietM.Lock()
+	quiet = false
+	quietM.Unlock()
+}
+
+// Quiet stops fetching new jobs from every queue, letting running jobs
+// finish. Call Quit to exit once they're done.
+func Quiet() {
+	access.Lock()
+	defer access.Unlock()
+
+	if !started || isQuiet() {
+		return
+	}
+
+	for _, m := range managers {
+		m.prepare()
+	}
+
+	quietM.Lock()
+	quiet = true
+	quietM.Unlock()
+}
+
+// setConcurrency changes the number of workers processing a running queue.
+func setConcurrency(queue string, concurrency int) error {
+	m, err := runningManager(queue, concurrency)
+	if err != nil {
+		return err
+	}
+
+	// Stopping workers waits for their jobs, so it's done without holding
+	// access, which Quit and Process need.
+	Logger.Println("changing concurrency of queue", m.queueName(), "from", m.size(), "to", concurrency)
+	m.setConcurrency(concurrency)
+	return nil
+}
+
+// runningManager returns the manager of a running queue, if its
+// concurrency can be changed.
+func runningManager(queue string, concurrency int) (*manager, error) {
+	access.Lock()
+	defer access.Unlock()
+
+	m, ok := managers[queue]
+	if !ok {
+		return nil, errors.New("not processing queue " + queue)
+	}
+	if concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	if !started || isQuiet() {
+		return nil, errors.New("not running")
+	}
+
+	return m, nil
+}
+
+// isQuiet has its own lock so heartbeats don't wait on Quit.
+func isQuiet() bool {
+	quietM.Lock()
+	defer quietM.Unlock()
+
+	return quiet
 }
 
 func StatsServer(port int) {
 	http.HandleFunc("/stats", Stats)
+	http.HandleFunc("/health", Health)
 
 	Logger.Println("Stats are available at", fmt.Sprint("http://localhost:", port, "/stats"))
 
@@ -89,6 +203,36 @@ func StatsServer(port int) {
 	}
 }
 
+func startHeartbeat() {
+	if beat == nil {
+		beat = newHeartbeat()
+	}
+
+	beat.start()
+}
+
+func quitHeartbeat() {
+	if beat != nil {
+		beat.quit()
+		beat = nil
+	}
+}
+
+func startCanceller() {
+	if cancels == nil {
+		cancels = newCanceller()
+	}
+
+	cancels.start()
+}
+
+func quitCanceller() {
+	if cancels != nil {
+		cancels.quit()
+		cancels = nil
+	}
+}
+
 func startSchedule() {
 	if schedule == nil {
 		schedule = newScheduled(RETRY_KEY, SCHEDULED_JOBS_KEY)
@@ -104,6 +248,21 @@ func quitSchedule() {
 	}
 }
 
+func startPeriodic() {
+	if cron == nil {
+		cron = newPeriodic()
+	}
+
+	cron.start()
+}
+
+func quitPeriodic() {
+	if cron != nil {
+		cron.quit()
+		cron = nil
+	}
+}
+
 func startManagers() {
 	for _, manager := range managers {
 		manager.start()
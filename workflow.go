@@ -0,0 +1,492 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// This is a variable for testing reasons
+var workflowTTL = 30 * 24 * time.Hour
+
+// The states of a workflow and its steps.
+const (
+	WorkflowRunning   = "running"
+	WorkflowSucceeded = "succeeded"
+	WorkflowFailed    = "failed"
+
+	StepPending     = "pending"
+	StepEnqueued    = "enqueued"
+	StepSucceeded   = "succeeded"
+	StepFailed      = "failed"
+	StepCompensated = "compensated"
+)
+
+// Workflow is a graph of jobs where each job runs once the jobs it depends
+// on have succeeded. Sequences are steps that each depend on the previous
+// one; fan-out is several steps depending on one, and fan-in one step
+// depending on several.
+//
+// When a step fails for the last time, no more steps are enqueued. With
+// CompensateOnFailure, the compensating jobs of the steps that succeeded
+// are enqueued too, most recently succeeded first.
+type Workflow struct {
+	ID                  string
+	Name                string
+	CompensateOnFailure bool
+
+	steps []*WorkflowStep
+}
+
+// WorkflowStep is one job of a workflow.
+type WorkflowStep struct {
+	Name      string         `json:"name"`
+	Queue     string         `json:"queue"`
+	Class     string         `json:"class"`
+	Args      interface{}    `json:"args"`
+	Options   EnqueueOptions `json:"options"`
+	DependsOn []string       `json:"depends_on,omitempty"`
+
+	Compensation *WorkflowJob `json:"compensation,omitempty"`
+}
+
+// WorkflowJob is a job enqueued to undo a step.
+type WorkflowJob struct {
+	Queue string      `json:"queue"`
+	Class string      `json:"class"`
+	Args  interface{} `json:"args"`
+}
+
+// Compensate sets the job enqueued to undo the step if the workflow fails
+// after it succeeded.
+func (s *WorkflowStep) Compensate(queue, class string, args interface{}) *WorkflowStep {
+	s.Compensation = &WorkflowJob{queue, class, args}
+	return s
+}
+
+// WorkflowStatus is the progress of a workflow.
+type WorkflowStatus struct {
+	ID         string
+	Name       string
+	State      string
+	Steps      map[string]string
+	Jids       map[string]string
+	FailedStep string
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// NewWorkflow describes a new workflow.
+func NewWorkflow(name string) *Workflow {
+	return &Workflow{ID: generateJid(), Name: name}
+}
+
+// Add adds a step that runs once the named steps have succeeded, or
+// straight away if it depends on none.
+func (w *Workflow) Add(name, queue, class string, args interface{}, dependsOn ...string) *WorkflowStep {
+	step := &WorkflowStep{
+		Name:      name,
+		Queue:     queue,
+		Class:     class,
+		Args:      args,
+		DependsOn: dependsOn,
+	}
+	w.steps = append(w.steps, step)
+	return step
+}
+
+// Start stores the workflow and enqueues the steps that don't depend on
+// any other. If one can't be enqueued, the workflow fails and the error is
+// returned.
+func (w *Workflow) Start() error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+
+	definition, err := json.Marshal(w.steps)
+	if err != nil {
+		return err
+	}
+
+	rc := Config.Client
+
+	steps := make(map[string]interface{})
+	waiting := make(map[string]interface{})
+	var roots []*WorkflowStep
+	for _, step := range w.steps {
+		if len(step.DependsOn) == 0 {
+			steps[step.Name] = StepEnqueued
+			roots = append(roots, step)
+		} else {
+			steps[step.Name] = StepPending
+			waiting[step.Name] = len(step.DependsOn)
+		}
+	}
+
+	pipe := rc.TxPipeline()
+	pipe.HMSet(workflowKey(w.ID), map[string]interface{}{
+		"name":       w.Name,
+		"state":      WorkflowRunning,
+		"compensate": fmt.Sprint(w.CompensateOnFailure),
+		"definition": string(definition),
+		"remaining":  len(w.steps),
+		"created_at": nowToSecondsWithNanoPrecision(),
+	})
+	pipe.HMSet(workflowStepsKey(w.ID), steps)
+	if len(waiting) > 0 {
+		pipe.HMSet(workflowWaitingKey(w.ID), waiting)
+	}
+	for _, key := range workflowKeys(w.ID) {
+		pipe.Expire(key, workflowTTL)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+
+	for _, step := range roots {
+		if err := enqueueWorkflowStep(w.ID, step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks step names are unique, dependencies exist, and there
+// are no cycles.
+func (w *Workflow) validate() error {
+	if len(w.steps) == 0 {
+		return errors.New("workflows require at least one step")
+	}
+
+	dependents := make(map[string][]string)
+	remaining := make(map[string]int)
+	for _, step := range w.steps {
+		if step.Name == "" || step.Queue == "" || step.Class == "" {
+			return errors.New("workflow steps require a Name, Queue and Class")
+		}
+		if _, ok := remaining[step.Name]; ok {
+			return errors.New("duplicate workflow step " + step.Name)
+		}
+		remaining[step.Name] = len(step.DependsOn)
+	}
+
+	var ready []string
+	for _, step := range w.steps {
+		for _, dependency := range step.DependsOn {
+			if _, ok := remaining[dependency]; !ok {
+				return fmt.Errorf("workflow step %s depends on unknown step %s", step.Name, dependency)
+			}
+			dependents[dependency] = append(dependents[dependency], step.Name)
+		}
+		if len(step.DependsOn) == 0 {
+			ready = append(ready, step.Name)
+		}
+	}
+
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if visited != len(w.steps) {
+		return errors.New("workflow steps have a dependency cycle")
+	}
+
+	return nil
+}
+
+// LoadWorkflow returns the progress of a workflow.
+func LoadWorkflow(id string) (*WorkflowStatus, error) {
+	rc := Config.Client
+
+	pipe := rc.Pipeline()
+	fields := pipe.HGetAll(workflowKey(id))
+	steps := pipe.HGetAll(workflowStepsKey(id))
+	jids := pipe.HGetAll(workflowJidsKey(id))
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	if len(fields.Val()) == 0 {
+		return nil, errors.New("no workflow with ID " + id)
+	}
+
+	return &WorkflowStatus{
+		ID:         id,
+		Name:       fields.Val()["name"],
+		State:      fields.Val()["state"],
+		Steps:      steps.Val(),
+		Jids:       jids.Val(),
+		FailedStep: fields.Val()["failed_step"],
+		Error:      fields.Val()["error"],
+		CreatedAt:  batchTime(fields.Val()["created_at"]),
+		FinishedAt: batchTime(fields.Val()["finished_at"]),
+	}, nil
+}
+
+// enqueueWorkflowStep enqueues a step that's been marked as enqueued. A
+// step that can't be enqueued, or is dropped by a client middleware, would
+// never finish, so it fails the workflow.
+func enqueueWorkflowStep(id string, step *WorkflowStep) error {
+	opts := step.Options
+	opts.Workflow = id
+	opts.WorkflowStep = step.Name
+
+	data := newEnqueueData(step.Queue, step.Class, step.Args, opts)
+
+	pushed, err := false, Config.Client.HSet(workflowJidsKey(id), step.Name, data.Jid).Err()
+	if err == nil {
+		pushed, err = enqueue(data)
+	}
+	if err == nil && pushed {
+		return nil
+	}
+
+	Config.Client.HDel(workflowJidsKey(id), step.Name)
+
+	if err == nil {
+		err = errors.New("workflow step " + step.Name + " was dropped by a client middleware")
+	}
+	if failErr := finishWorkflowStep(id, step.Name, false, err); failErr != nil {
+		Logger.Println("ERR: Couldn't fail workflow", id, "after step", step.Name, "wasn't enqueued:", failErr)
+	}
+	return err
+}
+
+// stepSucceededScript marks the step ARGV[1] as succeeded. While the
+// workflow is running, it counts down the dependencies of the steps in
+// ARGV[4...], returning the workflow's state followed by the steps that
+// are now ready. A step that already succeeded returns nothing.
+var stepSucceededScript = redis.NewScript(`
+local state = redis.call('hget', KEYS[1], 'state')
+if not state or redis.call('hget', KEYS[2], ARGV[1]) == 'succeeded' then
+	return {}
+end
+redis.call('hset', KEYS[2], ARGV[1], 'succeeded')
+redis.call('rpush', KEYS[4], ARGV[1])
+for _, key in ipairs(KEYS) do
+	redis.call('expire', key, ARGV[3])
+end
+local reply = {state}
+if state ~= 'running' then
+	return reply
+end
+for i = 4, #ARGV do
+	if redis.call('hincrby', KEYS[3], ARGV[i], -1) == 0 then
+		redis.call('hset', KEYS[2], ARGV[i], 'enqueued')
+		table.insert(reply, ARGV[i])
+	end
+end
+if redis.call('hincrby', KEYS[1], 'remaining', -1) == 0 then
+	redis.call('hmset', KEYS[1], 'state', 'succeeded', 'finished_at', ARGV[2])
+end
+return reply
+`)
+
+// stepFailedScript marks the step ARGV[1] as failed, failing the workflow
+// if it's running. Returns 1 if it failed the workflow.
+var stepFailedScript = redis.NewScript(`
+local state = redis.call('hget', KEYS[1], 'state')
+if not state then
+	return 0
+end
+redis.call('hset', KEYS[2], ARGV[1], 'failed')
+if state ~= 'running' then
+	return 0
+end
+redis.call('hmset', KEYS[1], 'state', 'failed', 'failed_step', ARGV[1], 'error', ARGV[3], 'finished_at', ARGV[2])
+return 1
+`)
+
+// compensateScript marks the step ARGV[1] as compensated if it succeeded,
+// so it's only compensated once. Returns 1 if the caller should compensate.
+var compensateScript = redis.NewScript(`
+if redis.call('hget', KEYS[1], ARGV[1]) ~= 'succeeded' then
+	return 0
+end
+redis.call('hset', KEYS[1], ARGV[1], 'compensated')
+return 1
+`)
+
+// finishWorkflowStep records the outcome of a step, enqueueing the steps
+// that were waiting on it or compensating the workflow if it failed.
+func finishWorkflowStep(id, name string, succeeded bool, stepErr error) error {
+	rc := Config.Client
+
+	fields, err := rc.HMGet(workflowKey(id), "definition", "compensate").Result()
+	if err != nil {
+		return err
+	}
+	definition, _ := fields[0].(string)
+	if definition == "" {
+		return nil
+	}
+	compensate, _ := fields[1].(string)
+
+	var steps []*WorkflowStep
+	if err := json.Unmarshal([]byte(definition), &steps); err != nil {
+		return err
+	}
+
+	if !succeeded {
+		failed, err := stepFailedScript.Run(rc,
+			[]string{workflowKey(id), workflowStepsKey(id)},
+			name, nowToSecondsWithNanoPrecision(), fmt.Sprint(stepErr),
+		).Int64()
+		if err != nil || failed == 0 || compensate != "true" {
+			return err
+		}
+
+		// Undo the steps that succeeded, most recent first
+		done, err := rc.LRange(workflowDoneKey(id), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			if err := compensateWorkflowStep(id, steps, done[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	args := []interface{}{name, nowToSecondsWithNanoPrecision(), int64(workflowTTL / time.Second)}
+	for _, step := range steps {
+		for _, dependency := range step.DependsOn {
+			if dependency == name {
+				args = append(args, step.Name)
+			}
+		}
+	}
+
+	reply, err := stepSucceededScript.Run(rc, workflowKeys(id), args...).Result()
+	if err != nil {
+		return err
+	}
+
+	values, _ := reply.([]interface{})
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Steps that finish after the workflow failed are undone too
+	if state, _ := values[0].(string); state == WorkflowFailed {
+		if compensate == "true" {
+			return compensateWorkflowStep(id, steps, name)
+		}
+		return nil
+	}
+
+	for _, value := range values[1:] {
+		ready, _ := value.(string)
+		for _, step := range steps {
+			if step.Name == ready {
+				if err := enqueueWorkflowStep(id, step); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func compensateWorkflowStep(id string, steps []*WorkflowStep, name string) error {
+	for _, step := range steps {
+		if step.Name != name || step.Compensation == nil {
+			continue
+		}
+
+		claimed, err := compensateScript.Run(Config.Client, []string{workflowStepsKey(id)}, name).Int64()
+		if err != nil || claimed == 0 {
+			return err
+		}
+
+		job := step.Compensation
+		_, err = EnqueueWithOptions(job.Queue, job.Class, job.Args, EnqueueOptions{})
+		return err
+	}
+
+	return nil
+}
+
+// WorkflowMiddleware records the outcome of workflow steps, enqueueing
+// the steps that depend on them. It's one of the default middlewares, and
+// should run outside RetryMiddleware.
+func WorkflowMiddleware(queue string, next JobFunc) JobFunc {
+	return func(message *Msg) (err error) {
+		id, _ := message.Get("wfid").String()
+		if id == "" {
+			return next(message)
+		}
+		name, _ := message.Get("wfstep").String()
+
+		before := RetrySnapshot(message)
+
+		defer func() {
+			e := recover()
+			if e != nil {
+				err = fmt.Errorf("%v", e)
+			}
+
+			var workflowErr error
+			switch JobOutcome(message, before, err) {
+			case StatusRetrying:
+				// Not final, the step runs again
+			case StatusComplete:
+				workflowErr = finishWorkflowStep(id, name, true, nil)
+			default:
+				workflowErr = finishWorkflowStep(id, name, false, err)
+			}
+			if workflowErr != nil {
+				Logger.Println("ERR: Couldn't update workflow", id, "for step", name, ":", workflowErr)
+			}
+
+			if e != nil {
+				panic(e)
+			}
+		}()
+
+		return next(message)
+	}
+}
+
+// Workflow keys share a hash tag, so scripts can use them together on a
+// cluster.
+func workflowKey(id string) string {
+	return Config.Namespace + WORKFLOW_KEY + ":{" + id + "}"
+}
+
+func workflowStepsKey(id string) string {
+	return workflowKey(id) + ":steps"
+}
+
+func workflowWaitingKey(id string) string {
+	return workflowKey(id) + ":waiting"
+}
+
+func workflowDoneKey(id string) string {
+	return workflowKey(id) + ":done"
+}
+
+func workflowJidsKey(id string) string {
+	return workflowKey(id) + ":jids"
+}
+
+// workflowKeys are the keys stepSucceededScript uses, in order.
+func workflowKeys(id string) []string {
+	return []string{workflowKey(id), workflowStepsKey(id), workflowWaitingKey(id), workflowDoneKey(id), workflowJidsKey(id)}
+}
@@ -0,0 +1,224 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runWorkflowJob runs a job from a queue through WorkflowMiddleware and
+// RetryMiddleware, like a worker would.
+func runWorkflowJob(t *testing.T, queue string, fail bool) string {
+	payload, err := Config.Client.RPop(Config.queueKey(queue)).Result()
+	assert.NoError(t, err)
+
+	message, _ := NewMsg(payload)
+	NewMiddlewares(WorkflowMiddleware, RetryMiddleware).build(queue, func(message *Msg) error {
+		if fail {
+			return errors.New("AHHHH")
+		}
+		return nil
+	})(message)
+
+	class, _ := message.Get("class").String()
+	return class
+}
+
+func TestWorkflow(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	workflow := NewWorkflow("import")
+	workflow.Add("download", "workflowQueue", "Download", nil)
+	workflow.Add("resize", "workflowQueue", "Resize", nil, "download")
+	workflow.Add("scan", "workflowQueue", "Scan", nil, "download")
+	workflow.Add("publish", "workflowQueue", "Publish", nil, "resize", "scan")
+	assert.NoError(t, workflow.Start())
+
+	//enqueues steps without dependencies
+	assert.Equal(t, []string{"Download"}, callbacks("workflowQueue"))
+
+	status, err := LoadWorkflow(workflow.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "import", status.Name)
+	assert.Equal(t, WorkflowRunning, status.State)
+	assert.Equal(t, StepEnqueued, status.Steps["download"])
+	assert.Equal(t, StepPending, status.Steps["publish"])
+	assert.NotEmpty(t, status.Jids["download"])
+
+	//fans out once a step succeeds
+	assert.Equal(t, "Download", runWorkflowJob(t, "workflowQueue", false))
+	assert.Equal(t, []string{"Scan", "Resize"}, callbacks("workflowQueue"))
+
+	//fans in once every dependency succeeds
+	runWorkflowJob(t, "workflowQueue", false)
+	assert.Equal(t, []string{"Scan"}, callbacks("workflowQueue"))
+
+	runWorkflowJob(t, "workflowQueue", false)
+	assert.Equal(t, []string{"Publish"}, callbacks("workflowQueue"))
+
+	runWorkflowJob(t, "workflowQueue", false)
+
+	status, _ = LoadWorkflow(workflow.ID)
+	assert.Equal(t, WorkflowSucceeded, status.State)
+	assert.Equal(t, StepSucceeded, status.Steps["publish"])
+	assert.False(t, status.FinishedAt.IsZero())
+
+	_, err = LoadWorkflow("missing")
+	assert.Error(t, err)
+}
+
+func TestWorkflowFailure(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	workflow := NewWorkflow("checkout")
+	workflow.CompensateOnFailure = true
+	workflow.Add("reserve", "workflowQueue", "Reserve", nil).Compensate("compensations", "Release", nil)
+	workflow.Add("charge", "workflowQueue", "Charge", nil, "reserve").Compensate("compensations", "Refund", nil)
+	workflow.Add("ship", "workflowQueue", "Ship", nil, "charge").Options = EnqueueOptions{Retry: true}
+	workflow.Add("email", "workflowQueue", "Email", nil, "ship")
+	assert.NoError(t, workflow.Start())
+
+	runWorkflowJob(t, "workflowQueue", false)
+	runWorkflowJob(t, "workflowQueue", false)
+
+	//keeps running while a step is retried
+	assert.Equal(t, "Ship", runWorkflowJob(t, "workflowQueue", true))
+
+	status, _ := LoadWorkflow(workflow.ID)
+	assert.Equal(t, WorkflowRunning, status.State)
+	assert.Equal(t, 0, len(callbacks("compensations")))
+
+	//halts and compensates succeeded steps once a step fails for good, most
+	//recent first
+	retries, _ := RetrySet().Page(0, -1)
+	assert.Equal(t, 1, len(retries))
+	assert.NoError(t, RetrySet().Delete(retries[0]))
+
+	retries[0].Set("retry", false)
+	Config.Client.LPush(Config.queueKey("workflowQueue"), retries[0].ToJson())
+	runWorkflowJob(t, "workflowQueue", true)
+
+	status, _ = LoadWorkflow(workflow.ID)
+	assert.Equal(t, WorkflowFailed, status.State)
+	assert.Equal(t, "ship", status.FailedStep)
+	assert.Equal(t, "AHHHH", status.Error)
+	assert.Equal(t, StepCompensated, status.Steps["reserve"])
+	assert.Equal(t, StepFailed, status.Steps["ship"])
+	assert.Equal(t, StepPending, status.Steps["email"])
+
+	assert.Equal(t, 0, len(callbacks("workflowQueue")))
+	assert.Equal(t, []string{"Release", "Refund"}, callbacks("compensations"))
+}
+
+func TestWorkflowDroppedStep(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			if data.Class == "Charge" {
+				return nil
+			}
+			return next(data)
+		}
+	})
+
+	workflow := NewWorkflow("checkout")
+	workflow.CompensateOnFailure = true
+	workflow.Add("reserve", "workflowQueue", "Reserve", nil).Compensate("compensations", "Release", nil)
+	workflow.Add("charge", "workflowQueue", "Charge", nil, "reserve")
+	assert.NoError(t, workflow.Start())
+
+	//fails the workflow when a step is dropped rather than waiting forever
+	runWorkflowJob(t, "workflowQueue", false)
+
+	status, _ := LoadWorkflow(workflow.ID)
+	assert.Equal(t, WorkflowFailed, status.State)
+	assert.Equal(t, "charge", status.FailedStep)
+	assert.Equal(t, StepFailed, status.Steps["charge"])
+	assert.Empty(t, status.Jids["charge"])
+	assert.Equal(t, []string{"Release"}, callbacks("compensations"))
+
+	//reports it when starting
+	dropped := NewWorkflow("dropped")
+	dropped.Add("charge", "workflowQueue", "Charge", nil)
+	assert.Error(t, dropped.Start())
+
+	status, _ = LoadWorkflow(dropped.ID)
+	assert.Equal(t, WorkflowFailed, status.State)
+}
+
+func TestWorkflowEnqueueErrors(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+	defer func() {
+		clientMiddlewares = nil
+	}()
+
+	UseClientMiddleware(func(next EnqueueFunc) EnqueueFunc {
+		return func(data *EnqueueData) error {
+			if data.Class == "Charge" {
+				return errors.New("rejected")
+			}
+			return next(data)
+		}
+	})
+
+	workflow := NewWorkflow("checkout")
+	workflow.CompensateOnFailure = true
+	workflow.Add("reserve", "workflowQueue", "Reserve", nil).Compensate("compensations", "Release", nil)
+	workflow.Add("charge", "workflowQueue", "Charge", nil, "reserve")
+	assert.NoError(t, workflow.Start())
+
+	//fails the workflow when a step that's ready can't be enqueued
+	runWorkflowJob(t, "workflowQueue", false)
+
+	status, _ := LoadWorkflow(workflow.ID)
+	assert.Equal(t, WorkflowFailed, status.State)
+	assert.Equal(t, "charge", status.FailedStep)
+	assert.Equal(t, "rejected", status.Error)
+	assert.Equal(t, StepFailed, status.Steps["charge"])
+	assert.Empty(t, status.Jids["charge"])
+	assert.Equal(t, []string{"Release"}, callbacks("compensations"))
+
+	//and when starting, so the roots after it aren't left unpushed
+	started := NewWorkflow("started")
+	started.Add("reserve", "workflowQueue", "Reserve", nil)
+	started.Add("charge", "workflowQueue", "Charge", nil)
+	started.Add("ship", "workflowQueue", "Ship", nil)
+	assert.Error(t, started.Start())
+
+	status, _ = LoadWorkflow(started.ID)
+	assert.Equal(t, WorkflowFailed, status.State)
+	assert.Equal(t, "charge", status.FailedStep)
+
+	//steps already enqueued finish without enqueuing anything else
+	assert.Equal(t, "Reserve", runWorkflowJob(t, "workflowQueue", false))
+	assert.Equal(t, 0, len(callbacks("workflowQueue")))
+}
+
+func TestWorkflowValidation(t *testing.T) {
+	setupTestConfigWithNamespace("prod")
+
+	assert.Error(t, NewWorkflow("empty").Start())
+
+	unknown := NewWorkflow("unknown")
+	unknown.Add("a", "workflowQueue", "A", nil, "missing")
+	assert.Error(t, unknown.Start())
+
+	duplicate := NewWorkflow("duplicate")
+	duplicate.Add("a", "workflowQueue", "A", nil)
+	duplicate.Add("a", "workflowQueue", "A", nil)
+	assert.Error(t, duplicate.Start())
+
+	cycle := NewWorkflow("cycle")
+	cycle.Add("a", "workflowQueue", "A", nil, "b")
+	cycle.Add("b", "workflowQueue", "B", nil, "a")
+	assert.Error(t, cycle.Start())
+
+	//doesn't store invalid workflows
+	_, err := LoadWorkflow(cycle.ID)
+	assert.Error(t, err)
+}